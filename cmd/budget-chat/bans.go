@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errBanned = errors.New("banned")
+
+// banList holds the addresses operators have banned. It is shared between
+// the hub, which adds bans, and the accept loop, which checks them, so unlike
+// the rest of the chat state it has a lock of its own.
+//
+// Bans are saved to a file of lines like "10.0.0.1 2022-12-01T13:00:00Z",
+// with "permanent" in place of the expiry for bans without one.
+type banList struct {
+	path string               // empty when bans are not saved
+	bans map[string]time.Time // by address, zero for permanent bans
+	sync.Mutex
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[string]time.Time)}
+}
+
+// loadBanList reads the bans saved at path, which need not exist yet.
+func loadBanList(path string) (*banList, error) {
+	b := newBanList()
+	b.path = path
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load bans: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		addr, expiry, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("load bans: line %d: missing expiry", line)
+		}
+
+		var until time.Time
+		if expiry != "permanent" {
+			if until, err = time.Parse(time.RFC3339, expiry); err != nil {
+				return nil, fmt.Errorf("load bans: line %d: %w", line, err)
+			}
+		}
+
+		b.bans[addr] = until
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("load bans: %w", err)
+	}
+
+	return b, nil
+}
+
+// add bans an address until the given time, or for good if it is zero.
+func (b *banList) add(addr string, until time.Time, now time.Time) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.bans[addr] = until
+
+	return b.save(now)
+}
+
+func (b *banList) banned(addr string, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	until, ok := b.bans[addr]
+	return ok && (until.IsZero() || now.Before(until))
+}
+
+// save writes the bans that have yet to expire.
+func (b *banList) save(now time.Time) error {
+	if b.path == "" {
+		return nil
+	}
+
+	var lines []string
+	for addr, until := range b.bans {
+		switch {
+		case until.IsZero():
+			lines = append(lines, addr+" permanent\n")
+		case now.Before(until):
+			lines = append(lines, addr+" "+until.UTC().Format(time.RFC3339)+"\n")
+		default:
+			delete(b.bans, addr)
+		}
+	}
+	sort.Strings(lines)
+
+	if err := writeFileAtomic(b.path, []byte(strings.Join(lines, ""))); err != nil {
+		return fmt.Errorf("save bans: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic replaces the file at path in one go, writing the data to a
+// temporary file beside it first, so that a crash cannot leave it half
+// written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// remoteIP is the address bans apply to, the host part of a connection's
+// remote address.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// botCommand answers a "!command", given the text after it. An empty answer
+// is not said.
+type botCommand func(m *message, args string) string
+
+// bot is a filter that answers messages starting with one of its
+// "!commands", saying its answers to the room under its own name. Every bot
+// answers "!help" with the commands it knows.
+type bot struct {
+	name     string
+	commands map[string]botCommand
+}
+
+func newBot(name string) *bot {
+	b := &bot{name: name, commands: make(map[string]botCommand)}
+	b.handle("help", b.help)
+	return b
+}
+
+// handle adds a command, e.g. handle("time", ...) for "!time".
+func (b *bot) handle(name string, cmd botCommand) {
+	b.commands[name] = cmd
+}
+
+func (b *bot) filter(m *message) bool {
+	if !strings.HasPrefix(m.text, "!") {
+		return true
+	}
+
+	name, args, _ := strings.Cut(m.text[1:], " ")
+	// commands the bot does not know may be for another
+	if cmd, ok := b.commands[name]; ok {
+		if answer := cmd(m, strings.TrimSpace(args)); answer != "" {
+			m.reply(b.name, answer)
+		}
+	}
+
+	return true
+}
+
+func (b *bot) help(m *message, args string) string {
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, "!"+name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf("I know %s", strings.Join(names, ", "))
+}
+
+// newTimeBot is a bot that answers "!time" with the time in UTC.
+func newTimeBot(name string, now func() time.Time) *bot {
+	b := newBot(name)
+	b.handle("time", func(m *message, args string) string {
+		return fmt.Sprintf("%s, the time is %s", m.from, now().UTC().Format(time.RFC1123))
+	})
+	return b
+}
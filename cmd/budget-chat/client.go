@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize is the number of messages that may wait to be written to a
+// client before it is considered too slow and disconnected.
+const defaultQueueSize = 64
+
+// writeTimeout bounds a single write, so that a client that stops reading
+// cannot hold its writer forever.
+const writeTimeout = 10 * time.Second
+
+type client struct {
+	name string // set and read by the hub goroutine alone
+	room *room  // set and read by the hub goroutine alone, nil until named
+	addr string
+	ip   string // what bans apply to
+
+	// set and read by the hub goroutine alone
+	connected   time.Time
+	active      time.Time // when the client last sent a line
+	flood       *floodState
+	oper        bool
+	muted       bool   // by an operator
+	identified  string // the registered name the client has proved it owns
+	wantedName  string // a registered name asked for with /nick, until identified
+	idleTimer   *time.Timer
+	pinged      time.Time // when last sent a keepalive
+	resumeToken string    // empty when the client may not be resumed
+	detached    bool      // dropped, but may yet be resumed
+	conn        net.Conn
+	proto       protocol
+
+	// messages waiting for the writer goroutine, closed once the client is
+	// removed or found to be too slow
+	outbox chan string
+	closed bool
+	sync.Mutex
+}
+
+func newClient(conn net.Conn, queueSize int, proto protocol) *client {
+	c := &client{
+		addr:   conn.RemoteAddr().String(),
+		ip:     remoteIP(conn),
+		conn:   conn,
+		proto:  proto,
+		outbox: make(chan string, queueSize),
+	}
+
+	go c.writeMessages()
+
+	return c
+}
+
+// send queues a notice from the server.
+func (c *client) send(msg string) {
+	c.queue(c.proto.notice(msg))
+}
+
+// sendEvent queues an event in the client's protocol.
+func (c *client) sendEvent(e event) {
+	if msg := c.proto.format(e); msg != "" {
+		c.queue(msg)
+	}
+}
+
+// queue adds a message for the client without waiting for it to be written.
+// A client whose queue is full is disconnected rather than holding up the
+// sender.
+func (c *client) queue(msg string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.outbox <- msg:
+	default:
+		log.Printf("disconnecting slow client %s\n", c.addr)
+		c.closed = true
+		close(c.outbox)
+		// unblocks the reader so that the client is removed
+		c.conn.Close()
+	}
+}
+
+// close stops further messages being queued. The connection is closed once
+// the messages already queued have been written.
+func (c *client) close() {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.outbox)
+	}
+}
+
+func (c *client) writeMessages() {
+	defer c.conn.Close()
+
+	failed := false
+	for msg := range c.outbox {
+		// keep draining after a failure so that senders are never held up
+		if failed {
+			continue
+		}
+
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := io.WriteString(c.conn, msg); err != nil {
+			log.Printf("write to %s: %s\n", c.addr, err)
+			failed = true
+			c.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// commandHandler runs a slash command on the hub goroutine, for a client that
+// has joined.
+type commandHandler func(h *hub, c *client, arg string)
+
+var commands = map[string]commandHandler{
+	"join":  joinRoom,
+	"leave": leaveRoom,
+	"rooms": listRooms,
+	"topic": setTopic,
+	"msg":   unlessMuted(privateMessage),
+	"who":   listUsers,
+	"names": listNames,
+
+	"nick":     changeNick,
+	"register": registerNick,
+	"identify": identifyNick,
+	"me":       unlessMuted(emote),
+
+	"oper":   becomeOper,
+	"kick":   operOnly(kickUser),
+	"ban":    operOnly(banUser),
+	"mute":   operOnly(muteUser),
+	"unmute": operOnly(unmuteUser),
+}
+
+// parseCommand splits a line such as "/join dev" into the command name and
+// its argument, reporting false for lines that are not a command. Unknown
+// commands are reported by the hub.
+func parseCommand(line string) (string, string, bool) {
+	if !strings.HasPrefix(line, "/") {
+		return "", "", false
+	}
+
+	name, arg, _ := strings.Cut(line[1:], " ")
+	return name, strings.TrimSpace(arg), true
+}
+
+// secretCommands take a password or token, which is never logged.
+var secretCommands = map[string]bool{
+	"oper":     true,
+	"register": true,
+	"identify": true,
+	"resume":   true,
+}
+
+// redact hides the argument of a line carrying a secret, so that the line can
+// be logged.
+func redact(line string) string {
+	if name, arg, ok := parseCommand(line); ok && secretCommands[name] && arg != "" {
+		return "/" + name + " [redacted]"
+	}
+	return line
+}
+
+// cutArg splits the first word from the rest of an argument.
+func cutArg(arg string) (string, string, bool) {
+	first, rest, ok := strings.Cut(arg, " ")
+	return first, strings.TrimSpace(rest), ok
+}
+
+// unlessMuted wraps a command that talks to other users.
+func unlessMuted(run commandHandler) commandHandler {
+	return func(h *hub, c *client, arg string) {
+		if c.muted {
+			c.send("* You are muted\n")
+			return
+		}
+		run(h, c, arg)
+	}
+}
+
+func (h *hub) runCommand(c *client, name string, arg string) {
+	run, ok := commands[name]
+	if !ok {
+		c.send(fmt.Sprintf("* Unknown command: /%s\n", name))
+		return
+	}
+
+	run(h, c, arg)
+}
+
+func joinRoom(h *hub, c *client, arg string) {
+	if arg == "" {
+		c.send("* Usage: /join <room>\n")
+		return
+	}
+
+	arg = h.namePolicy.normalize(arg)
+	if !h.validRoomName(arg) {
+		c.send(fmt.Sprintf("* Invalid room name: %s\n", arg))
+		return
+	}
+
+	if c.room.name == arg {
+		c.send(fmt.Sprintf("* You are already in %s\n", arg))
+		return
+	}
+
+	h.enter(c, arg)
+}
+
+// leaveRoom returns a client to the default room.
+func leaveRoom(h *hub, c *client, arg string) {
+	if c.room.name == defaultRoom {
+		c.send("* You are already in the default room\n")
+		return
+	}
+
+	h.enter(c, defaultRoom)
+}
+
+func listRooms(h *hub, c *client, arg string) {
+	var rooms []string
+	for _, name := range h.roomNames() {
+		r := h.rooms[name]
+		rooms = append(rooms, fmt.Sprintf("%s (%d)", name, len(r.members)+len(r.remotes)))
+	}
+
+	c.send(fmt.Sprintf("* Rooms: %s\n", strings.Join(rooms, ", ")))
+}
+
+// setTopic shows the topic of the client's room, or changes it when given
+// one.
+func setTopic(h *hub, c *client, arg string) {
+	r := c.room
+
+	if arg == "" {
+		if r.topic == "" {
+			c.send(fmt.Sprintf("* No topic is set for %s\n", r.name))
+		} else {
+			c.send(fmt.Sprintf("* The topic for %s is: %s\n", r.name, r.topic))
+		}
+		return
+	}
+
+	r.topic = arg
+
+	e := topicEvent{room: r.name, topic: r.topic, setBy: c.name}
+	r.broadcast(c, e)
+	c.sendEvent(e)
+}
+
+// validRoomName follows the rules for client names, though rooms may take
+// reserved names.
+func (h *hub) validRoomName(name string) bool {
+	return h.namePolicy.valid(name)
+}
+
+// privateMessage sends a line to a single user, in any room, echoing it back
+// to the sender.
+func privateMessage(h *hub, c *client, arg string) {
+	name, text, _ := cutArg(arg)
+	if name == "" || text == "" {
+		c.send("* Usage: /msg <name> <text>\n")
+		return
+	}
+
+	to, ok := h.named(name)
+	if !ok {
+		c.send(fmt.Sprintf("* No such user: %s\n", name))
+		return
+	}
+
+	e := privateEvent{from: c.name, to: to.name, text: text}
+	to.sendEvent(e)
+	if to != c {
+		c.sendEvent(e)
+	}
+}
+
+// listUsers lists the users who have joined, with how long they have been
+// connected and idle. The list is queued as a single message so that a long
+// one cannot overflow the client's queue.
+func listUsers(h *hub, c *client, arg string) {
+	now := h.now()
+
+	var users []string
+	for _, u := range h.clients {
+		if u.name == "" {
+			continue
+		}
+		users = append(users, fmt.Sprintf("* %s (%s): connected %s, idle %s\n",
+			u.name, u.room.name, now.Sub(u.connected).Truncate(time.Second), now.Sub(u.active).Truncate(time.Second)))
+	}
+
+	c.send(fmt.Sprintf("* Users online: %d\n%s", len(users), strings.Join(users, "")))
+}
+
+// listNames lists the members of the client's room.
+func listNames(h *hub, c *client, arg string) {
+	c.sendEvent(namesEvent{room: c.room.name, names: c.room.names()})
+}
+
+// emote describes an action to the rest of the room, e.g. "/me waves".
+func emote(h *hub, c *client, arg string) {
+	if arg == "" {
+		c.send("* Usage: /me <action>\n")
+		return
+	}
+
+	h.sayFiltered(c, arg, func(action string) event {
+		return emoteEvent{from: c.name, room: c.room.name, action: action}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An event is something that happens in a room, which each client is told of
+// in its own protocol. line gives the budget-chat line for it.
+type event interface {
+	line() string
+}
+
+type chatEvent struct {
+	from string
+	room string
+	text string
+}
+
+func (e chatEvent) line() string {
+	return fmt.Sprintf("[%s] %s\n", e.from, e.text)
+}
+
+type emoteEvent struct {
+	from   string
+	room   string
+	action string
+}
+
+func (e emoteEvent) line() string {
+	return fmt.Sprintf("* %s %s\n", e.from, e.action)
+}
+
+type privateEvent struct {
+	from string
+	to   string
+	text string
+}
+
+func (e privateEvent) line() string {
+	return fmt.Sprintf("[%s -> %s] %s\n", e.from, e.to, e.text)
+}
+
+type enterEvent struct {
+	name string
+	room string
+}
+
+func (e enterEvent) line() string {
+	return fmt.Sprintf("* %s has entered the room\n", e.name)
+}
+
+type leaveEvent struct {
+	name string
+	room string
+	quit bool // disconnected, rather than moved to another room
+}
+
+func (e leaveEvent) line() string {
+	return fmt.Sprintf("* %s has left the room\n", e.name)
+}
+
+type nickEvent struct {
+	from string
+	to   string
+	room string
+}
+
+func (e nickEvent) line() string {
+	return fmt.Sprintf("* %s is now known as %s\n", e.from, e.to)
+}
+
+// namesEvent lists the other members of a room, either on entering it or
+// when asked.
+type namesEvent struct {
+	room    string
+	names   []string
+	entered bool
+}
+
+func (e namesEvent) line() string {
+	return fmt.Sprintf("* The room contains: %s\n", strings.Join(e.names, ", "))
+}
+
+// topicEvent gives the topic of a room on entering it, or announces a change
+// to it when setBy is set.
+type topicEvent struct {
+	room  string
+	topic string
+	setBy string
+}
+
+func (e topicEvent) line() string {
+	if e.setBy != "" {
+		return fmt.Sprintf("* %s set the topic to: %s\n", e.setBy, e.topic)
+	}
+	return fmt.Sprintf("* The topic is: %s\n", e.topic)
+}
+
+// pingEvent checks that a quiet client is still there.
+type pingEvent struct{}
+
+func (e pingEvent) line() string {
+	return "* ping\n"
+}
+
+// protocol formats what a client is sent. Both are called on the hub
+// goroutine, apart from notices sent before a client is named.
+type protocol interface {
+	// format returns what to send for an event, or "" to send nothing.
+	format(e event) string
+	// notice formats one or more lines from the server.
+	notice(text string) string
+}
+
+// lineProtocol is budget-chat's own protocol of plain lines.
+type lineProtocol struct{}
+
+func (lineProtocol) format(e event) string {
+	return e.line()
+}
+
+func (lineProtocol) notice(text string) string {
+	return text
+}
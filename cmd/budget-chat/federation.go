@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Linked servers share their rooms, telling each other of joins, leaves,
+// messages and name changes in lines of tab separated fields:
+//
+//	<id> <kind> <room> <user> <text>
+//
+// The id is the name of the server that sent the line, when that server
+// started and a sequence number, so that a restarted server's ids are new.
+// The user is "name@server" after the server they are connected to.
+// Servers pass on what they hear to their other links, so need not all be
+// linked to each other. The first line each way is "server <name>".
+const (
+	linkJoin    = "join"
+	linkLeave   = "leave"
+	linkQuit    = "quit"
+	linkMessage = "message"
+	linkEmote   = "emote"
+	linkNick    = "nick"
+)
+
+// linkQueueSize is larger than a client's, as a link carries what is said
+// on every server behind it.
+const linkQueueSize = 1024
+
+// linkHandshakeTimeout bounds the wait for a peer to give its name.
+const linkHandshakeTimeout = 10 * time.Second
+
+// defaultServerName is used when the host has no name.
+const defaultServerName = "budgetchat"
+
+// defaultPeerRetry is how long to wait before relinking to a peer.
+const defaultPeerRetry = 10 * time.Second
+
+// seenLimit is how many line ids are remembered to drop lines that have come
+// back around a loop of links.
+const seenLimit = 4096
+
+// link is a connection to a peer server. Its lines are queued and written like
+// a client's, so a slow peer never holds up the hub.
+type link struct {
+	server string // the peer's name
+	conn   *client
+}
+
+// remoteUser is a member of a room on another server.
+type remoteUser struct {
+	name string // "name@server"
+	room *room  // nil between leaving one room and entering another
+	via  *link  // the link the user was first heard of through
+}
+
+type linkRequest struct {
+	link     *link
+	accepted chan bool // false when the link is refused
+}
+
+type receivedLine struct {
+	from *link
+	line string
+}
+
+// seenSet holds the ids of recent lines.
+type seenSet struct {
+	ids   map[string]bool
+	order []string // oldest first
+}
+
+// add reports false if the id has been seen before.
+func (s *seenSet) add(id string) bool {
+	if s.ids == nil {
+		s.ids = make(map[string]bool)
+	}
+	if s.ids[id] {
+		return false
+	}
+
+	s.ids[id] = true
+	s.order = append(s.order, id)
+	if len(s.order) > seenLimit {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// validServerName checks a name is fit to follow the '@' of a user's name.
+func validServerName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "@ \t")
+}
+
+// StartFederation accepts links from peer servers on a port of their own.
+func (s *ChatServer) StartFederation(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	log.Println("listening for peers on port", port)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		go func() {
+			if err := s.serveLink(conn); err != nil {
+				log.Printf("link from %s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Peer keeps a link to the server at addr, relinking whenever it drops.
+func (s *ChatServer) Peer(addr string, retry time.Duration) {
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			err = s.serveLink(conn)
+		}
+		if err == nil {
+			err = io.EOF
+		}
+
+		log.Printf("link to %s: %s, relinking in %s\n", addr, err, retry)
+		time.Sleep(retry)
+	}
+}
+
+// serveLink exchanges names with a peer, then passes what it says to the hub
+// until the link drops.
+func (s *ChatServer) serveLink(conn net.Conn) error {
+	l := &link{conn: newClient(conn, linkQueueSize, lineProtocol{})}
+	defer l.conn.close()
+
+	l.conn.queue(fmt.Sprintf("server\t%s\n", s.hub.serverName))
+
+	scanner := bufio.NewScanner(conn)
+	conn.SetReadDeadline(time.Now().Add(linkHandshakeTimeout))
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("handshake: %w", err)
+		}
+		return fmt.Errorf("handshake: %w", io.ErrUnexpectedEOF)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	kind, name, _ := strings.Cut(scanner.Text(), "\t")
+	if kind != "server" || !validServerName(name) {
+		return fmt.Errorf("handshake: unexpected %q", scanner.Text())
+	}
+
+	l.server = name
+	if !s.hub.linkUp(l) {
+		return fmt.Errorf("link to %s refused, as it is this server or already reachable", name)
+	}
+	defer s.hub.linkDown(l)
+
+	for scanner.Scan() {
+		s.hub.receive(l, scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// linkUp adds a link, reporting false if it is refused.
+func (h *hub) linkUp(l *link) bool {
+	accepted := make(chan bool)
+	h.linkUps <- linkRequest{link: l, accepted: accepted}
+	return <-accepted
+}
+
+// receive passes on a line from a peer.
+func (h *hub) receive(l *link, line string) {
+	h.linkLines <- receivedLine{from: l, line: line}
+}
+
+// linkDown removes a link that has dropped.
+func (h *hub) linkDown(l *link) {
+	h.linkDowns <- l
+}
+
+// handleLinkUp adds a link, unless it is to this server or to one already
+// reachable, then tells the peer who is in each room.
+func (h *hub) handleLinkUp(l *link) bool {
+	if l.server == h.serverName {
+		return false
+	}
+	for _, other := range h.links {
+		if other.server == l.server {
+			return false
+		}
+	}
+	for name := range h.remotes {
+		if strings.HasSuffix(name, "@"+l.server) {
+			return false
+		}
+	}
+
+	for _, name := range h.roomNames() {
+		r := h.rooms[name]
+		for _, c := range r.members {
+			l.conn.queue(h.line(linkJoin, r.name, c.name+"@"+h.serverName, ""))
+		}
+		for _, u := range r.remotes {
+			l.conn.queue(h.line(linkJoin, r.name, u.name, ""))
+		}
+	}
+
+	h.links = append(h.links, l)
+	log.Printf("linked to %s [# links: %d]\n", l.server, len(h.links))
+
+	return true
+}
+
+// handleLinkDown removes a link, telling the rooms here and the servers still
+// linked that the users heard of through it have quit.
+func (h *hub) handleLinkDown(l *link) {
+	for i, other := range h.links {
+		if other == l {
+			h.links = append(h.links[:i], h.links[i+1:]...)
+			break
+		}
+	}
+
+	var split []string
+	for name, u := range h.remotes {
+		if u.via == l {
+			split = append(split, name)
+		}
+	}
+	sort.Strings(split)
+
+	log.Printf("link to %s dropped, losing %d users [# links: %d]\n", l.server, len(split), len(h.links))
+
+	for _, name := range split {
+		u := h.remotes[name]
+		room := ""
+		if u.room != nil {
+			room = u.room.name
+			h.remoteExit(u, true)
+		}
+		delete(h.remotes, name)
+		h.sendLinks(nil, h.line(linkQuit, room, name, ""))
+	}
+}
+
+// handleLine applies a line from a peer to the rooms here, then passes it on
+// to the other links. Lines already seen, about users here, or about remote
+// users from a link other than the one they were heard of through are
+// dropped, so that lines cannot go around a loop of links.
+func (h *hub) handleLine(l *link, line string) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		log.Printf("link to %s: malformed line %q\n", l.server, line)
+		return
+	}
+	id, kind, roomName, user, text := fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	at := strings.LastIndex(user, "@")
+	if at <= 0 || !validServerName(user[at+1:]) || (roomName == "" && kind != linkQuit) {
+		log.Printf("link to %s: malformed line %q\n", l.server, line)
+		return
+	}
+	origin := user[at+1:]
+
+	if h.seen.ids[id] || origin == h.serverName {
+		return
+	}
+
+	// a line from the wrong link may yet arrive from the right one, so is
+	// not marked as seen
+	u, known := h.remotes[user]
+	if known && u.via != l {
+		return
+	}
+	if !known && kind != linkJoin {
+		return
+	}
+	h.seen.add(id)
+
+	switch kind {
+	case linkJoin:
+		if !known {
+			u = &remoteUser{name: user, via: l}
+			h.remotes[user] = u
+		} else if u.room != nil {
+			if u.room.name == roomName {
+				return
+			}
+			h.remoteExit(u, false)
+		}
+		h.remoteEnter(u, roomName)
+	case linkLeave, linkQuit:
+		if u.room != nil {
+			h.remoteExit(u, kind == linkQuit)
+		}
+		if kind == linkQuit {
+			delete(h.remotes, user)
+		}
+	case linkMessage, linkEmote:
+		if u.room == nil || u.room.name != roomName {
+			return
+		}
+
+		var e event = chatEvent{from: user, room: roomName, text: text}
+		if kind == linkEmote {
+			e = emoteEvent{from: user, room: roomName, action: text}
+		}
+		h.record(e)
+		u.room.say(nil, e, h.now())
+	case linkNick:
+		renamed := text + "@" + origin
+		if _, taken := h.remotes[renamed]; taken || text == "" {
+			return
+		}
+
+		delete(h.remotes, user)
+		u.name = renamed
+		h.remotes[renamed] = u
+
+		if u.room != nil {
+			e := nickEvent{from: user, to: renamed, room: u.room.name}
+			h.record(e)
+			u.room.broadcast(nil, e)
+		}
+	default:
+		log.Printf("link to %s: unknown kind %q\n", l.server, kind)
+		return
+	}
+
+	h.sendLinks(l, line+"\n")
+}
+
+// remoteEnter puts a remote user in the named room, creating it if need be.
+func (h *hub) remoteEnter(u *remoteUser, name string) {
+	r := h.room(name)
+
+	e := enterEvent{name: u.name, room: r.name}
+	h.record(e)
+	r.broadcast(nil, e)
+
+	r.remotes = append(r.remotes, u)
+	u.room = r
+}
+
+// remoteExit takes a remote user out of its room.
+func (h *hub) remoteExit(u *remoteUser, quit bool) {
+	r := u.room
+	for i, other := range r.remotes {
+		if other == u {
+			r.remotes = append(r.remotes[:i], r.remotes[i+1:]...)
+			break
+		}
+	}
+	u.room = nil
+
+	e := leaveEvent{name: u.name, room: r.name, quit: quit}
+	h.record(e)
+	r.broadcast(nil, e)
+
+	h.prune(r)
+}
+
+// relay tells the linked servers of something a client here has done.
+func (h *hub) relay(e event) {
+	if len(h.links) == 0 {
+		return
+	}
+
+	var kind, room, name, text string
+	switch e := e.(type) {
+	case chatEvent:
+		kind, room, name, text = linkMessage, e.room, e.from, e.text
+	case emoteEvent:
+		kind, room, name, text = linkEmote, e.room, e.from, e.action
+	case enterEvent:
+		kind, room, name = linkJoin, e.room, e.name
+	case leaveEvent:
+		kind, room, name = linkLeave, e.room, e.name
+		if e.quit {
+			kind = linkQuit
+		}
+	case nickEvent:
+		kind, room, name, text = linkNick, e.room, e.from, e.to
+	default:
+		return
+	}
+
+	h.sendLinks(nil, h.line(kind, room, name+"@"+h.serverName, text))
+}
+
+// line formats a line from this server, giving it a new id.
+func (h *hub) line(kind string, room string, user string, text string) string {
+	h.relayed++
+	id := fmt.Sprintf("%s:%s:%d", h.serverName, h.started, h.relayed)
+	h.seen.add(id)
+
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n", id, kind, room, user, text)
+}
+
+// sendLinks queues a line for every link but one.
+func (h *hub) sendLinks(except *link, line string) {
+	for _, l := range h.links {
+		if l != except {
+			l.conn.queue(line)
+		}
+	}
+}
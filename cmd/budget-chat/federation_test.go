@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newNamedServer(name string) *ChatServer {
+	s := NewChatServer(5000)
+	s.hub.serverName = name
+	return s
+}
+
+// linkServers links two servers over a pipe, returning the end to close to
+// split them.
+func linkServers(a *ChatServer, b *ChatServer) net.Conn {
+	aConn, bConn := net.Pipe()
+	go a.serveLink(aConn)
+	go b.serveLink(bConn)
+	return aConn
+}
+
+// connectTo starts a client of a server.
+func connectTo(s *ChatServer) (net.Conn, *bufio.Scanner) {
+	clientConn, serverConn := net.Pipe()
+	serveTestConns(s, serverConn)
+	return clientConn, bufio.NewScanner(clientConn)
+}
+
+func TestFederation(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	east := newNamedServer("east")
+	west := newNamedServer("west")
+
+	aliceClientConn, alice := connectTo(east)
+	bobClientConn, bob := connectTo(west)
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "")
+
+	// each server tells the other who is there on linking
+	split := linkServers(east, west)
+	m.assert(alice, "* Bob@west has entered the room")
+	m.assert(bob, "* Alice@east has entered the room")
+
+	bobClientConn.Write([]byte("/names\n"))
+	m.assert(bob, "* The room contains: Bob, Alice@east")
+
+	aliceClientConn.Write([]byte("Hello from the east\n"))
+	m.assert(bob, "[Alice@east] Hello from the east")
+
+	bobClientConn.Write([]byte("/me waves\n"))
+	m.assert(alice, "* Bob@west waves")
+
+	carolClientConn, carol := connectTo(east)
+	defer carolClientConn.Close()
+	join(m, carolClientConn, carol, "Carol", "Alice, Bob@west")
+	m.assert(alice, "* Carol has entered the room")
+	m.assert(bob, "* Carol@east has entered the room")
+
+	aliceClientConn.Write([]byte("/nick Alicia\n"))
+	m.assert(alice, "* Alice is now known as Alicia")
+	m.assert(carol, "* Alice is now known as Alicia")
+	m.assert(bob, "* Alice@east is now known as Alicia@east")
+
+	// rooms are shared too
+	bobClientConn.Write([]byte("/join dev\n"))
+	m.assert(bob, "* The room contains: ")
+	m.assert(alice, "* Bob@west has left the room")
+	m.assert(carol, "* Bob@west has left the room")
+
+	aliceClientConn.Write([]byte("/join dev\n"))
+	m.assert(alice, "* The room contains: Bob@west")
+	m.assert(carol, "* Alicia has left the room")
+	m.assert(bob, "* Alicia@east has entered the room")
+
+	carolClientConn.Write([]byte("/rooms\n"))
+	m.assert(carol, "* Rooms: dev (2), general (1)")
+
+	// a netsplit is announced as the remote users leaving
+	split.Close()
+	m.assert(alice, "* Bob@west has left the room")
+	m.assert(bob, "* Alicia@east has left the room")
+
+	aliceClientConn.Write([]byte("/names\n"))
+	m.assert(alice, "* The room contains: Alicia")
+}
+
+func TestFederationRelay(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	a, b, c := newNamedServer("a"), newNamedServer("b"), newNamedServer("c")
+
+	aliceClientConn, alice := connectTo(a)
+	carolClientConn, carol := connectTo(c)
+	defer aliceClientConn.Close()
+	defer carolClientConn.Close()
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, carolClientConn, carol, "Carol", "")
+
+	// a and c hear of each other through b
+	defer linkServers(a, b).Close()
+	defer linkServers(b, c).Close()
+	m.assert(alice, "* Carol@c has entered the room")
+	m.assert(carol, "* Alice@a has entered the room")
+
+	// so a link between them would make a loop
+	aConn, cConn := net.Pipe()
+	errs := make(chan error, 2)
+	go func() { errs <- a.serveLink(aConn) }()
+	go func() { errs <- c.serveLink(cConn) }()
+	m.is.True(<-errs != nil)
+	m.is.True(<-errs != nil)
+
+	aliceClientConn.Write([]byte("Hello from a\n"))
+	m.assert(carol, "[Alice@a] Hello from a")
+
+	carolClientConn.Write([]byte("Hello from c\n"))
+	m.assert(alice, "[Carol@c] Hello from c")
+}
+
+func TestFederationRestart(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	east := newNamedServer("east")
+
+	aliceClientConn, alice := connectTo(east)
+	defer aliceClientConn.Close()
+	join(m, aliceClientConn, alice, "Alice", "")
+
+	link := func(west *ChatServer) (net.Conn, net.Conn, *bufio.Scanner) {
+		bobClientConn, bob := connectTo(west)
+		join(m, bobClientConn, bob, "Bob", "")
+
+		split := linkServers(east, west)
+		m.assert(alice, "* Bob@west has entered the room")
+		m.assert(bob, "* Alice@east has entered the room")
+
+		bobClientConn.Write([]byte("Hello from the west\n"))
+		m.assert(alice, "[Bob@west] Hello from the west")
+		return split, bobClientConn, bob
+	}
+
+	split, bobClientConn, _ := link(newNamedServer("west"))
+	split.Close()
+	m.assert(alice, "* Bob@west has left the room")
+	bobClientConn.Close()
+
+	// a restarted server numbers its lines afresh, but they are not taken for
+	// those already seen
+	split, bobClientConn, _ = link(newNamedServer("west"))
+	defer split.Close()
+	defer bobClientConn.Close()
+}
+
+func TestFederationLoop(t *testing.T) {
+	is := is.New(t)
+
+	// a hub not running, so that lines can be handled one at a time
+	h := newHub()
+	h.serverName = "a"
+
+	newLink := func(server string) (*link, *bufio.Scanner) {
+		conn, peerConn := net.Pipe()
+		t.Cleanup(func() { peerConn.Close() })
+		return &link{server: server, conn: newClient(conn, linkQueueSize, lineProtocol{})}, bufio.NewScanner(peerConn)
+	}
+	b, _ := newLink("b")
+	c, fromC := newLink("c")
+	h.links = []*link{b, c}
+
+	handle := func(l *link, line string) {
+		h.handleLine(l, line)
+	}
+	names := func() []string {
+		return h.rooms[defaultRoom].names()
+	}
+	h.room(defaultRoom)
+
+	// Bob is heard of through b, then again through c around a loop
+	handle(b, "b:1\tjoin\tgeneral\tBob@b\t")
+	handle(c, "b:1\tjoin\tgeneral\tBob@b\t")
+	handle(c, "c:5\tjoin\tgeneral\tBob@b\t")
+	is.Equal(names(), []string{"Bob@b"})
+
+	// only b may speak for Bob, but what comes from c first is not forgotten
+	handle(c, "b:2\tquit\tgeneral\tBob@b\t")
+	is.Equal(names(), []string{"Bob@b"})
+	handle(b, "b:2\tquit\tgeneral\tBob@b\t")
+	is.Equal(names(), []string{})
+
+	// each was passed on to c once
+	fromC.Scan()
+	is.Equal(fromC.Text(), "b:1\tjoin\tgeneral\tBob@b\t")
+	fromC.Scan()
+	is.Equal(fromC.Text(), "b:2\tquit\tgeneral\tBob@b\t")
+
+	// lines about users here, and malformed lines, are dropped
+	handle(b, "b:3\tjoin\tgeneral\tAlice@a\t")
+	handle(b, "b:4\tjoin\tgeneral\tCarol\t")
+	handle(b, "b:5\tjoin\tgeneral")
+	handle(b, "b:6\tmessage\tgeneral\tDave@b\tHi")
+	is.Equal(names(), []string{})
+	is.Equal(len(h.remotes), 0)
+}
+
+func TestFederationRefused(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	a, b := newNamedServer("a"), newNamedServer("b")
+
+	// servers cannot share a name
+	aConn, twinConn := net.Pipe()
+	errs := make(chan error, 2)
+	go func() { errs <- a.serveLink(aConn) }()
+	go func() { errs <- newNamedServer("a").serveLink(twinConn) }()
+	m.is.True(<-errs != nil)
+	m.is.True(<-errs != nil)
+
+	aliceClientConn, alice := connectTo(a)
+	bobClientConn, bob := connectTo(b)
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "")
+
+	defer linkServers(a, b).Close()
+	m.assert(alice, "* Bob@b has entered the room")
+	m.assert(bob, "* Alice@a has entered the room")
+
+	// nor be linked twice
+	aConn, bConn := net.Pipe()
+	go func() { errs <- a.serveLink(aConn) }()
+	go func() { errs <- b.serveLink(bConn) }()
+	m.is.True(<-errs != nil)
+	m.is.True(<-errs != nil)
+}
+
+func TestSeenSet(t *testing.T) {
+	is := is.New(t)
+
+	var s seenSet
+	is.True(s.add("a:1"))
+	is.True(!s.add("a:1")) // seen
+
+	for i := 0; i < seenLimit; i++ {
+		s.add(fmt.Sprintf("b:%d", i))
+	}
+	is.Equal(len(s.order), seenLimit)
+	is.True(s.add("a:1")) // forgotten
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/russellslater/protohackers/cmd/mob-in-the-middle/chatproxy"
+)
+
+// message is a line said in a room, as filters see it.
+type message struct {
+	from    string
+	room    string
+	text    string
+	replies []chatEvent
+}
+
+// reply has a line said to the room once the message has been, whether or not
+// the message itself is dropped.
+func (m *message) reply(from string, text string) {
+	m.replies = append(m.replies, chatEvent{from: from, room: m.room, text: text})
+}
+
+// A filter sees each message said in a room before it is broadcast, in the
+// order filters were added. Filters run on the hub goroutine, so need no
+// locking, but must not block.
+type filter interface {
+	// filter may change the message's text or reply to it, and returns false
+	// to drop it, which also keeps it from later filters.
+	filter(m *message) bool
+}
+
+// rewriterFilter runs messages through a rewriter, as the mob-in-the-middle
+// proxy does.
+type rewriterFilter struct {
+	chatproxy.Rewriter
+}
+
+func (f rewriterFilter) filter(m *message) bool {
+	m.text = f.Rewrite(m.text)
+	return true
+}
+
+// wordMasker is a rewriter that replaces words, in any case, with asterisks.
+type wordMasker struct {
+	regex *regexp.Regexp
+}
+
+func newWordMasker(words []string) *wordMasker {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+
+	return &wordMasker{
+		regex: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+func (w *wordMasker) Rewrite(src string) string {
+	return w.regex.ReplaceAllStringFunc(src, func(word string) string {
+		return strings.Repeat("*", len(word))
+	})
+}
+
+func (w *wordMasker) RewriteBytes(src []byte) []byte {
+	return []byte(w.Rewrite(string(src)))
+}
+
+// sayFiltered says what a client said once it has passed the filters, followed
+// by any replies to it. event gives what is said for the filtered text.
+func (h *hub) sayFiltered(c *client, text string, event func(text string) event) {
+	m := &message{from: c.name, room: c.room.name, text: text}
+
+	keep := true
+	for _, f := range h.filters {
+		if keep = f.filter(m); !keep {
+			break
+		}
+	}
+
+	if keep {
+		h.say(c, event(m.text))
+	}
+
+	// replies are for the sender too
+	for _, e := range m.replies {
+		h.record(e)
+		c.room.say(nil, e, h.now())
+	}
+}
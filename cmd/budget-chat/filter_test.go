@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestWordMasker(t *testing.T) {
+	w := newWordMasker([]string{"darn", "heck"})
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "Well darn it", expected: "Well **** it"},
+		{input: "DARN! What the Heck?", expected: "****! What the ****?"},
+		{input: "darning socks", expected: "darning socks"},
+		{input: "", expected: ""},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(w.Rewrite(tc.input), tc.expected)
+			is.Equal(string(w.RewriteBytes([]byte(tc.input))), tc.expected)
+		})
+	}
+}
+
+func TestBot(t *testing.T) {
+	is := is.New(t)
+
+	now := func() time.Time { return time.Date(2022, 12, 1, 12, 30, 0, 0, time.FixedZone("", 3600)) }
+	b := newTimeBot("Clock", now)
+	b.handle("quiet", func(m *message, args string) string { return "" })
+
+	m := &message{from: "Alice", room: "general", text: "!time"}
+	is.True(b.filter(m))
+	is.Equal(m.replies, []chatEvent{{from: "Clock", room: "general", text: "Alice, the time is Thu, 01 Dec 2022 11:30:00 UTC"}})
+
+	m = &message{from: "Alice", room: "general", text: "!help me"}
+	is.True(b.filter(m))
+	is.Equal(m.replies, []chatEvent{{from: "Clock", room: "general", text: "I know !help, !quiet, !time"}})
+
+	for _, text := range []string{"time", "!quiet", "!unknown", "!"} {
+		m = &message{from: "Alice", room: "general", text: text}
+		is.True(b.filter(m))
+		is.Equal(len(m.replies), 0) // nothing to answer
+	}
+}
+
+// dropFilter drops messages containing a word, telling the sender why.
+type dropFilter struct {
+	word string
+}
+
+func (f dropFilter) filter(m *message) bool {
+	if strings.Contains(m.text, f.word) {
+		m.reply("Filter", m.from+", that was dropped")
+		return false
+	}
+	return true
+}
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.now = func() time.Time { return time.Date(2022, 12, 1, 12, 30, 0, 0, time.UTC) }
+	s.hub.filters = []filter{
+		rewriterFilter{newWordMasker([]string{"darn"})},
+		dropFilter{word: "spam"},
+		newTimeBot("Clock", s.hub.now),
+	}
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	aliceClientConn.Write([]byte("Oh darn\n"))
+	m.assert(bob, "[Alice] Oh ****")
+
+	aliceClientConn.Write([]byte("/me darn near fell over\n"))
+	m.assert(bob, "* Alice **** near fell over")
+
+	// the bot answers the whole room, the sender included
+	bobClientConn.Write([]byte("!time\n"))
+	m.assert(alice, "[Bob] !time")
+	m.assert(alice, "[Clock] Bob, the time is Thu, 01 Dec 2022 12:30:00 UTC")
+	m.assert(bob, "[Clock] Bob, the time is Thu, 01 Dec 2022 12:30:00 UTC")
+
+	// a dropped message is not said, nor seen by later filters
+	bobClientConn.Write([]byte("!time for spam\n"))
+	m.assert(bob, "[Filter] Bob, that was dropped")
+	m.assert(alice, "[Filter] Bob, that was dropped")
+
+	bobClientConn.Write([]byte("Sorry\n"))
+	m.assert(alice, "[Bob] Sorry")
+}
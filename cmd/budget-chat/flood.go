@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// floodConfig limits how fast a client may send lines. A client breaking the
+// limits is first warned, then muted and finally disconnected. Every limit is
+// off unless set.
+type floodConfig struct {
+	messageRate  float64 // lines per second, unlimited when 0
+	messageBurst int     // a second's worth when 0
+	byteRate     float64 // bytes per second, unlimited when 0
+	byteBurst    int     // a second's worth when 0
+	maxLength    int     // the longest line in bytes, unlimited when 0
+
+	warnings int           // warnings before a client is muted
+	mutes    int           // mutes before a client is disconnected
+	muteFor  time.Duration // how long a mute lasts, never muted when 0
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket starts a full bucket. A burst of 0 holds a second's worth of
+// tokens, and at least one.
+func newTokenBucket(rate float64, burst int, now time.Time) tokenBucket {
+	b := float64(burst)
+	if burst == 0 {
+		b = math.Max(math.Ceil(rate), 1)
+	}
+	return tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// take removes n tokens, reporting false if there are not enough.
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if b.rate == 0 {
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// floodState tracks a single client against the limits.
+type floodState struct {
+	messages   tokenBucket
+	bytes      tokenBucket
+	strikes    int
+	mutedUntil time.Time
+}
+
+func newFloodState(cfg floodConfig, now time.Time) *floodState {
+	return &floodState{
+		messages: newTokenBucket(cfg.messageRate, cfg.messageBurst, now),
+		bytes:    newTokenBucket(cfg.byteRate, cfg.byteBurst, now),
+	}
+}
+
+// admit decides whether a line of n bytes from a client may be handled,
+// escalating against clients that break the limits. Lines sent while muted
+// count against the client too.
+func (h *hub) admit(c *client, n int) bool {
+	now := h.now()
+	f := c.flood
+
+	var reason string
+	switch {
+	case now.Before(f.mutedUntil):
+		reason = fmt.Sprintf("you are muted for another %s", f.mutedUntil.Sub(now).Round(time.Second))
+	case h.flood.maxLength > 0 && n > h.flood.maxLength:
+		reason = fmt.Sprintf("lines may be at most %d characters", h.flood.maxLength)
+	case !f.messages.take(1, now) || !f.bytes.take(float64(n), now):
+		reason = "you are sending too fast"
+	default:
+		return true
+	}
+
+	f.strikes++
+
+	switch {
+	case f.strikes <= h.flood.warnings:
+		c.send(fmt.Sprintf("* Slow down, %s\n", reason))
+	case h.flood.muteFor > 0 && f.strikes <= h.flood.warnings+h.flood.mutes:
+		f.mutedUntil = now.Add(h.flood.muteFor)
+		c.send(fmt.Sprintf("* You have been muted for %s, %s\n", h.flood.muteFor, reason))
+	default:
+		log.Printf("disconnecting %s for flooding\n", c.addr)
+		disconnect(c, "* You have been disconnected for flooding\n")
+	}
+
+	return false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTokenBucket(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	b := newTokenBucket(1, 2, now)
+	is.True(b.take(1, now))
+	is.True(b.take(1, now))
+	is.True(!b.take(1, now)) // burst used up
+
+	now = now.Add(500 * time.Millisecond)
+	is.True(!b.take(1, now)) // half a token refilled
+
+	now = now.Add(500 * time.Millisecond)
+	is.True(b.take(1, now))
+
+	now = now.Add(time.Hour)
+	is.True(b.take(2, now))
+	is.True(!b.take(1, now)) // refilled no further than the burst
+
+	unlimited := newTokenBucket(0, 0, now)
+	is.True(unlimited.take(1000, now))
+
+	// without a burst, a second's worth may be sent at once
+	b = newTokenBucket(2.5, 0, now)
+	is.True(b.take(3, now))
+	is.True(!b.take(1, now))
+
+	b = newTokenBucket(0.5, 0, now)
+	is.True(b.take(1, now))
+	is.True(!b.take(1, now))
+}
+
+func TestFloodEscalation(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+
+	s := NewChatServer(5000)
+	s.hub.now = clock.Now
+	s.hub.flood = floodConfig{
+		messageRate:  1,
+		messageBurst: 2,
+		maxLength:    10,
+		warnings:     1,
+		mutes:        2,
+		muteFor:      10 * time.Second,
+	}
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	send := func(line string) {
+		aliceClientConn.Write([]byte(line + "\n"))
+	}
+
+	send("one")
+	m.assert(bob, "[Alice] one")
+	send("two")
+	m.assert(bob, "[Alice] two")
+
+	// a first offence is a warning
+	send("this line is too long")
+	m.assert(alice, "* Slow down, lines may be at most 10 characters")
+
+	// then mutes
+	send("three")
+	m.assert(alice, "* You have been muted for 10s, you are sending too fast")
+
+	clock.Advance(4 * time.Second)
+	send("four")
+	m.assert(alice, "* You have been muted for 10s, you are muted for another 6s")
+
+	// the mute wears off, by when the bucket has refilled
+	clock.Advance(11 * time.Second)
+	send("five")
+	m.assert(bob, "[Alice] five")
+	send("six")
+	m.assert(bob, "[Alice] six")
+
+	// and a client that carries on is disconnected
+	send("seven")
+	m.assert(alice, "* You have been disconnected for flooding")
+	m.assert(bob, "* Alice has left the room")
+	m.is.True(!alice.Scan()) // connection closed
+}
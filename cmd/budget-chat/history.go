@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// historyConfig decides what a room keeps to replay to newcomers. History is
+// disabled when both limits are 0, as the Protohackers checker expects nothing
+// after the room list.
+type historyConfig struct {
+	lines int           // the most recent lines kept per room, unlimited when 0
+	age   time.Duration // the oldest line kept and replayed, unlimited when 0
+}
+
+// enabled reports whether rooms keep any history.
+func (c historyConfig) enabled() bool {
+	return c.lines > 0 || c.age > 0
+}
+
+type historyEntry struct {
+	at   time.Time
+	line string
+}
+
+// history holds the most recent lines said in a room, oldest first, dropping
+// those beyond its limits as new ones are added.
+type history struct {
+	limits  historyConfig
+	entries []historyEntry
+}
+
+func newHistory(limits historyConfig) *history {
+	return &history{limits: limits}
+}
+
+func (h *history) add(at time.Time, line string) {
+	h.entries = append(h.entries, historyEntry{at: at, line: line})
+
+	drop := 0
+	if h.limits.lines > 0 && len(h.entries) > h.limits.lines {
+		drop = len(h.entries) - h.limits.lines
+	}
+	if h.limits.age > 0 {
+		oldest := at.Add(-h.limits.age)
+		for drop < len(h.entries) && h.entries[drop].at.Before(oldest) {
+			drop++
+		}
+	}
+
+	// the dropped entries are freed when append next grows the slice
+	h.entries = h.entries[drop:]
+}
+
+// since returns the lines said no earlier than the given time, oldest first.
+func (h *history) since(t time.Time) []historyEntry {
+	for i, e := range h.entries {
+		if !e.at.Before(t) {
+			return h.entries[i:]
+		}
+	}
+	return nil
+}
+
+// replay marks a line as history, so that it cannot be mistaken for one said
+// just now.
+func (e historyEntry) replay() string {
+	return fmt.Sprintf("* history %s %s", e.at.Format("15:04:05"), e.line)
+}
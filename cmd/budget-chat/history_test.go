@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestHistoryLimits(t *testing.T) {
+	is := is.New(t)
+
+	start := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	h := newHistory(historyConfig{lines: 3})
+	is.Equal(len(h.since(time.Time{})), 0)
+
+	for i := 0; i < 5; i++ {
+		h.add(start.Add(time.Duration(i)*time.Minute), fmt.Sprintf("line %d\n", i))
+	}
+
+	var lines []string
+	for _, e := range h.since(time.Time{}) {
+		lines = append(lines, e.line)
+	}
+	is.Equal(lines, []string{"line 2\n", "line 3\n", "line 4\n"}) // oldest dropped
+
+	recent := h.since(start.Add(3 * time.Minute))
+	is.Equal(len(recent), 2)
+	is.Equal(recent[0].replay(), "* history 12:03:00 line 3\n")
+
+	// limited by age alone, as many lines are kept as were said in time
+	h = newHistory(historyConfig{age: 2 * time.Minute})
+	for i := 0; i < 5; i++ {
+		h.add(start.Add(time.Duration(i)*time.Minute), fmt.Sprintf("line %d\n", i))
+	}
+
+	lines = nil
+	for _, e := range h.since(time.Time{}) {
+		lines = append(lines, e.line)
+	}
+	is.Equal(lines, []string{"line 2\n", "line 3\n", "line 4\n"}) // older dropped
+}
+
+func TestHistoryOutlivesRoom(t *testing.T) {
+	is := is.New(t)
+
+	h := newHub()
+	h.history = historyConfig{lines: 2}
+
+	r := h.room("dev")
+	r.say(nil, chatEvent{from: "Alice", room: "dev", text: "Hi"}, h.now())
+	h.prune(r)
+	_, ok := h.rooms["dev"]
+	is.True(!ok) // emptied
+
+	entries := h.room("dev").history.since(time.Time{})
+	is.Equal(len(entries), 1)
+	is.Equal(entries[0].line, "[Alice] Hi\n")
+}
+
+func TestHistoryForgotten(t *testing.T) {
+	is := is.New(t)
+
+	clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+	h := newHub()
+	h.now = clock.Now
+	h.history = historyConfig{lines: 2, age: time.Hour}
+
+	say := func(name string) {
+		r := h.room(name)
+		r.say(nil, chatEvent{from: "Alice", room: name, text: "Hi"}, h.now())
+		h.prune(r)
+	}
+
+	// an emptied room's history goes once too old to replay
+	say("old")
+	clock.Advance(2 * time.Hour)
+	h.prune(h.room("dev")) // any prune will do
+	_, ok := h.histories["old"]
+	is.True(!ok) // outside -history-age
+
+	// and the least recently used go beyond the cap
+	for i := 0; i <= maxEmptiedHistories; i++ {
+		say(fmt.Sprintf("room-%d", i))
+		clock.Advance(time.Second)
+	}
+	is.Equal(len(h.histories), maxEmptiedHistories)
+	_, ok = h.histories["room-0"]
+	is.True(!ok) // said in least recently
+	_, ok = h.histories[fmt.Sprintf("room-%d", maxEmptiedHistories)]
+	is.True(ok)
+
+	// rooms in use keep theirs
+	r := h.room("room-0")
+	r.say(nil, chatEvent{from: "Alice", room: "room-0", text: "Hi"}, h.now())
+	clock.Advance(2 * time.Hour)
+	h.prune(h.room("dev"))
+	is.Equal(len(h.histories), 1)
+	is.Equal(len(r.history.since(time.Time{})), 1)
+}
+
+func TestHistoryReplay(t *testing.T) {
+	tt := []struct {
+		name     string
+		history  historyConfig
+		expected []string
+	}{
+		{
+			name:     "Disabled",
+			history:  historyConfig{},
+			expected: []string{"* The room contains: Alice", "[Alice] after"},
+		},
+		{
+			name:    "Last Lines",
+			history: historyConfig{lines: 2},
+			expected: []string{
+				"* The room contains: Alice",
+				"* history 12:02:00 [Alice] two",
+				"* history 12:03:00 * Alice waves",
+				"[Alice] after",
+			},
+		},
+		{
+			name:    "Last Minutes",
+			history: historyConfig{lines: 10, age: time.Minute},
+			expected: []string{
+				"* The room contains: Alice",
+				"* history 12:03:00 * Alice waves",
+				"[Alice] after",
+			},
+		},
+		{
+			name:    "Age Alone",
+			history: historyConfig{age: time.Minute},
+			expected: []string{
+				"* The room contains: Alice",
+				"* history 12:03:00 * Alice waves",
+				"[Alice] after",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := newMessageExpecter(t)
+
+			clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+
+			s := NewChatServer(5000)
+			s.hub.now = clock.Now
+			s.hub.history = tc.history
+
+			aliceClientConn, aliceServerConn := net.Pipe()
+			defer aliceClientConn.Close()
+			serveTestConns(s, aliceServerConn)
+
+			alice := bufio.NewScanner(aliceClientConn)
+			join(m, aliceClientConn, alice, "Alice", "")
+
+			// history is kept per room
+			aliceClientConn.Write([]byte("/join dev\n"))
+			m.assert(alice, "* The room contains: ")
+			aliceClientConn.Write([]byte("elsewhere\n"))
+			aliceClientConn.Write([]byte("/leave\n"))
+			m.assert(alice, "* The room contains: ")
+
+			for _, line := range []string{"one", "two", "/me waves"} {
+				clock.Advance(time.Minute)
+				aliceClientConn.Write([]byte(line + "\n"))
+
+				// a command's reply shows that the line has been handled
+				aliceClientConn.Write([]byte("/rooms\n"))
+				m.assert(alice, "* Rooms: general (1)")
+			}
+			clock.Advance(30 * time.Second)
+
+			bobClientConn, bobServerConn := net.Pipe()
+			defer bobClientConn.Close()
+			serveTestConns(s, bobServerConn)
+
+			bob := bufio.NewScanner(bobClientConn)
+			m.assert(bob, "Welcome to budgetchat! What shall I call you?")
+			bobClientConn.Write([]byte("Bob\n"))
+			m.assert(alice, "* Bob has entered the room")
+
+			// marks the end of what is replayed
+			aliceClientConn.Write([]byte("after\n"))
+
+			for _, want := range tc.expected {
+				m.assert(bob, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+// defaultRoom is joined by every client once named, so that clients unaware
+// of rooms see a single room as before.
+const defaultRoom = "general"
+
+// maxEmptiedHistories caps how many rooms no longer in use keep their history,
+// so that clients joining ever more rooms cannot grow it without limit.
+const maxEmptiedHistories = 100
+
+// hub owns the rooms of the chat and their membership. Its state is only
+// touched by the run goroutine, which the connection goroutines talk to over
+// channels, so no locking is needed.
+type hub struct {
+	connects chan *client
+	joins    chan joinRequest
+	messages chan chatMessage
+	commands chan command
+	leaves   chan *client
+	lists    chan chan []string
+
+	// the outcomes of /register and /identify, hashed off the hub goroutine
+	passwordChecks chan passwordCheck
+
+	touches       chan *client
+	idleChecks    chan *client // clients that may have been idle too long
+	graceExpiries chan *client // dropped clients, once their time to resume is up
+
+	linkUps   chan linkRequest
+	linkLines chan receivedLine
+	linkDowns chan *link
+
+	// every connection, named or not, in order of arrival
+	clients []*client
+	rooms   map[string]*room
+
+	history   historyConfig
+	histories map[string]*history // by room, including some rooms since emptied
+	flood     floodConfig
+	idle      idleConfig
+
+	resumeGrace time.Duration // how long a dropped client may be resumed (not at all when 0)
+
+	operPassword string // operators are disabled when empty
+	bans         *banList
+
+	transcript *transcript.Log // nil when disabled
+
+	namePolicy namePolicy // fixed once clients connect
+
+	filters []filter // run over every message said, in order
+
+	serverName string // what users here are known as elsewhere, after an '@'
+	links      []*link
+	remotes    map[string]*remoteUser // by "name@server"
+	seen       seenSet
+	relayed    int    // lines sent so far, numbering their ids
+	started    string // the start time put in ids, so that a restart gives new ones
+
+	nicks *nickStore
+
+	now func() time.Time // replaced in tests
+}
+
+type room struct {
+	name    string
+	topic   string
+	members []*client     // in order of joining
+	remotes []*remoteUser // members on other servers, in order of joining
+	history *history      // nil when history is disabled
+}
+
+type joinRequest struct {
+	client     *client
+	name       string
+	identified bool      // the client gave the password of the registered name
+	token      string    // set to resume a client that dropped, in place of a name
+	joined     chan bool // false when the name is taken or the token unknown
+}
+
+type chatMessage struct {
+	from *client
+	text string
+}
+
+type command struct {
+	from *client
+	name string
+	arg  string
+	done chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		connects:  make(chan *client),
+		joins:     make(chan joinRequest),
+		messages:  make(chan chatMessage),
+		commands:  make(chan command),
+		leaves:    make(chan *client),
+		lists:     make(chan chan []string),
+		rooms:     make(map[string]*room),
+		histories: make(map[string]*history),
+		bans:      newBanList(),
+		now:       time.Now,
+
+		passwordChecks: make(chan passwordCheck),
+		touches:        make(chan *client),
+		idleChecks:     make(chan *client),
+		graceExpiries:  make(chan *client),
+		linkUps:        make(chan linkRequest),
+		linkLines:      make(chan receivedLine),
+		linkDowns:      make(chan *link),
+		serverName:     defaultServerName,
+		started:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		remotes:        make(map[string]*remoteUser),
+		nicks:          newNickStore(),
+	}
+}
+
+func (h *hub) run() {
+	for {
+		select {
+		case c := <-h.connects:
+			c.connected, c.active = h.now(), h.now()
+			c.flood = newFloodState(h.flood, h.now())
+			h.clients = append(h.clients, c)
+			h.watchIdle(c)
+			log.Printf("connection from %s [# connected clients: %d]\n", c.addr, len(h.clients))
+		case req := <-h.joins:
+			req.client.active = h.now()
+			if req.token != "" {
+				req.joined <- h.handleResume(req.client, req.token)
+			} else {
+				req.joined <- h.handleJoin(req.client, req.name, req.identified)
+			}
+		case msg := <-h.messages:
+			msg.from.active = h.now()
+			if !h.admit(msg.from, len(msg.text)) {
+				break
+			}
+			if msg.from.muted {
+				msg.from.send("* You are muted\n")
+				break
+			}
+			if msg.from.room != nil {
+				h.sayFiltered(msg.from, msg.text, func(text string) event {
+					return chatEvent{from: msg.from.name, room: msg.from.room.name, text: text}
+				})
+			}
+		case cmd := <-h.commands:
+			cmd.from.active = h.now()
+			// commands are limited too, as each can send a reply
+			if h.admit(cmd.from, len(cmd.name)+len(cmd.arg)+2) {
+				h.runCommand(cmd.from, cmd.name, cmd.arg)
+			}
+			close(cmd.done)
+		case c := <-h.leaves:
+			h.handleLeave(c)
+		case names := <-h.lists:
+			names <- h.names()
+		case p := <-h.passwordChecks:
+			h.handlePasswordCheck(p)
+		case req := <-h.linkUps:
+			req.accepted <- h.handleLinkUp(req.link)
+		case l := <-h.linkLines:
+			h.handleLine(l.from, l.line)
+		case l := <-h.linkDowns:
+			h.handleLinkDown(l)
+		case c := <-h.touches:
+			c.active = h.now()
+		case c := <-h.idleChecks:
+			h.checkIdle(c)
+		case c := <-h.graceExpiries:
+			h.expireGrace(c)
+		}
+	}
+}
+
+// connect adds a client that has yet to choose a name.
+func (h *hub) connect(c *client) {
+	h.connects <- c
+}
+
+// join names a client and puts it in the default room, reporting false if
+// the name is already taken. A registered name is only given to a client
+// that identified as its owner.
+func (h *hub) join(c *client, name string, identified bool) bool {
+	joined := make(chan bool)
+	h.joins <- joinRequest{client: c, name: name, identified: identified, joined: joined}
+	return <-joined
+}
+
+// message sends a line from a named client to the rest of its room.
+func (h *hub) message(c *client, text string) {
+	h.messages <- chatMessage{from: c, text: text}
+}
+
+// command runs a slash command for a named client, returning once it has
+// been handled.
+func (h *hub) command(c *client, name string, arg string) {
+	done := make(chan struct{})
+	h.commands <- command{from: c, name: name, arg: arg, done: done}
+	<-done
+}
+
+// leave removes a client, telling its room if it had joined one.
+func (h *hub) leave(c *client) {
+	h.leaves <- c
+}
+
+// list returns the names of the clients that have joined, across all rooms.
+func (h *hub) list() []string {
+	names := make(chan []string)
+	h.lists <- names
+	return <-names
+}
+
+func (h *hub) handleJoin(c *client, name string, identified bool) bool {
+	if _, taken := h.named(name); taken {
+		return false
+	}
+	// the name may have been registered since the client was let have it
+	if h.nicks.registered(name) && !identified {
+		return false
+	}
+
+	c.name = name
+	if identified {
+		c.identified = name
+	}
+	h.enter(c, defaultRoom)
+	h.issueResumeToken(c)
+	h.watchIdle(c)
+
+	return true
+}
+
+func (h *hub) handleLeave(c *client) {
+	if h.detach(c) {
+		return
+	}
+
+	h.forget(c)
+	if c.room != nil {
+		h.exit(c, true)
+	}
+
+	log.Printf("connection from %s closed [# connected clients: %d]\n", c.addr, len(h.clients))
+}
+
+// forget removes a client from the list of connections.
+func (h *hub) forget(c *client) {
+	for i, other := range h.clients {
+		if other == c {
+			h.clients = append(h.clients[:i], h.clients[i+1:]...)
+			break
+		}
+	}
+	h.stopWatchingIdle(c)
+}
+
+// enter moves a client into the named room, creating it if need be.
+func (h *hub) enter(c *client, name string) {
+	if c.room != nil {
+		h.exit(c, false)
+	}
+
+	r := h.room(name)
+
+	entered := enterEvent{name: c.name, room: r.name}
+	h.record(entered)
+	h.relay(entered)
+	r.broadcast(c, entered)
+	c.sendEvent(namesEvent{room: r.name, names: r.names(), entered: true})
+	if r.history != nil {
+		since := time.Time{}
+		if h.history.age > 0 {
+			since = h.now().Add(-h.history.age)
+		}
+		for _, e := range r.history.since(since) {
+			c.send(e.replay())
+		}
+	}
+	if r.topic != "" {
+		c.sendEvent(topicEvent{room: r.name, topic: r.topic})
+	}
+
+	r.members = append(r.members, c)
+	c.room = r
+}
+
+// exit takes a client out of its room. quit is set when the client is leaving the chat altogether.
+func (h *hub) exit(c *client, quit bool) {
+	r := c.room
+	for i, m := range r.members {
+		if m == c {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			break
+		}
+	}
+	c.room = nil
+
+	left := leaveEvent{name: c.name, room: r.name, quit: quit}
+	h.record(left)
+	h.relay(left)
+	r.broadcast(c, left)
+
+	h.prune(r)
+}
+
+// room finds the named room, creating it if need be.
+func (h *hub) room(name string) *room {
+	if r, ok := h.rooms[name]; ok {
+		return r
+	}
+
+	r := &room{name: name}
+	if h.history.enabled() {
+		r.history = h.roomHistory(name)
+	}
+	h.rooms[name] = r
+	return r
+}
+
+// roomHistory finds the history of the named room, which is kept while the
+// room comes and goes.
+func (h *hub) roomHistory(name string) *history {
+	if hist, ok := h.histories[name]; ok {
+		return hist
+	}
+
+	hist := newHistory(h.history)
+	h.histories[name] = hist
+	return hist
+}
+
+// prune removes a room once empty, unless it is the default.
+func (h *hub) prune(r *room) {
+	if len(r.members) == 0 && len(r.remotes) == 0 && r.name != defaultRoom {
+		delete(h.rooms, r.name)
+		h.forgetHistories()
+	}
+}
+
+// forgetHistories drops the history of rooms no longer in use once none of it
+// is within -history-age, then those said in least recently beyond
+// maxEmptiedHistories.
+func (h *hub) forgetHistories() {
+	var since time.Time
+	if h.history.age > 0 {
+		since = h.now().Add(-h.history.age)
+	}
+
+	var emptied []string
+	for name, hist := range h.histories {
+		if _, ok := h.rooms[name]; ok {
+			continue
+		}
+		if len(hist.since(since)) == 0 {
+			delete(h.histories, name)
+			continue
+		}
+		emptied = append(emptied, name)
+	}
+	if len(emptied) <= maxEmptiedHistories {
+		return
+	}
+
+	newest := func(name string) time.Time {
+		entries := h.histories[name].entries
+		return entries[len(entries)-1].at
+	}
+	sort.Slice(emptied, func(i, j int) bool {
+		return newest(emptied[i]).Before(newest(emptied[j]))
+	})
+	for _, name := range emptied[:len(emptied)-maxEmptiedHistories] {
+		delete(h.histories, name)
+	}
+}
+
+// named finds a client that has joined by name.
+func (h *hub) named(name string) (*client, bool) {
+	for _, c := range h.clients {
+		if c.name != "" && h.namePolicy.same(c.name, name) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// names lists the clients that have joined, across all rooms.
+func (h *hub) names() []string {
+	names := []string{}
+	for _, c := range h.clients {
+		if c.name != "" {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// roomNames lists the rooms in name order.
+func (h *hub) roomNames() []string {
+	names := make([]string, 0, len(h.rooms))
+	for name := range h.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// names lists the members of the room, followed by those on other servers.
+func (r *room) names() []string {
+	names := make([]string, 0, len(r.members)+len(r.remotes))
+	for _, c := range r.members {
+		names = append(names, c.name)
+	}
+	for _, u := range r.remotes {
+		names = append(names, u.name)
+	}
+	return names
+}
+
+// say broadcasts what a client said to its room.
+func (h *hub) say(c *client, e event) {
+	h.record(e)
+	h.relay(e)
+	c.room.say(c, e, h.now())
+}
+
+// say broadcasts what a member said, keeping it in the room's history.
+func (r *room) say(from *client, e event, at time.Time) {
+	if r.history != nil {
+		r.history.add(at, e.line())
+	}
+	r.broadcast(from, e)
+}
+
+// broadcast queues an event for every member of the room other than the
+// sender. Slow or failed clients are dealt with by their own writers, so
+// delivery to the rest is never held up.
+func (r *room) broadcast(from *client, e event) {
+	for _, c := range r.members {
+		if c != from {
+			c.sendEvent(e)
+		}
+	}
+}
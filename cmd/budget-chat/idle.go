@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// idleConfig disconnects clients that have gone quiet, as a client that
+// vanishes without closing its connection would otherwise stay forever.
+type idleConfig struct {
+	unnamed   time.Duration // to give a name (unlimited when 0)
+	named     time.Duration // silence before a named client is disconnected (unlimited when 0)
+	keepalive time.Duration // silence before a named client is pinged (never when 0)
+}
+
+// touch notes that a client is still there without it saying anything, e.g.
+// an IRC client answering a PING.
+func (h *hub) touch(c *client) {
+	h.touches <- c
+}
+
+// watchIdle has the client checked for idleness when it next could be.
+func (h *hub) watchIdle(c *client) {
+	at, ok := h.idleDeadline(c)
+	if !ok {
+		return
+	}
+
+	d := at.Sub(h.now())
+	if c.idleTimer == nil {
+		c.idleTimer = time.AfterFunc(d, func() {
+			h.idleChecks <- c
+		})
+	} else {
+		c.idleTimer.Reset(d)
+	}
+}
+
+// stopWatchingIdle stops the checks of a client that has gone.
+func (h *hub) stopWatchingIdle(c *client) {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+// idleDeadline is when the client is next to be pinged or disconnected, if
+// ever.
+func (h *hub) idleDeadline(c *client) (time.Time, bool) {
+	if c.name == "" {
+		if h.idle.unnamed == 0 {
+			return time.Time{}, false
+		}
+		return c.connected.Add(h.idle.unnamed), true
+	}
+
+	var at time.Time
+	if h.idle.keepalive > 0 && !c.pinged.After(c.active) {
+		at = c.active.Add(h.idle.keepalive)
+	}
+	if h.idle.named > 0 {
+		if d := c.active.Add(h.idle.named); at.IsZero() || d.Before(at) {
+			at = d
+		}
+	}
+
+	return at, !at.IsZero()
+}
+
+// checkIdle disconnects a client that has been quiet too long, or pings one
+// that has been quiet a while. Checks are only scheduled for when one of
+// these could be due, so a client that has said something since is checked
+// again later.
+func (h *hub) checkIdle(c *client) {
+	if !h.connected(c) || c.detached {
+		return
+	}
+
+	now := h.now()
+	switch {
+	case c.name == "" && h.idle.unnamed > 0 && !now.Before(c.connected.Add(h.idle.unnamed)):
+		log.Printf("disconnecting %s for not giving a name\n", c.addr)
+		c.send("* You took too long to give a name\n")
+		c.close()
+		return
+	case c.name != "" && h.idle.named > 0 && !now.Before(c.active.Add(h.idle.named)):
+		log.Printf("disconnecting %s for being idle\n", c.addr)
+		c.send(fmt.Sprintf("* Disconnected after %s idle\n", h.idle.named))
+		c.close()
+		return
+	case c.name != "" && h.idle.keepalive > 0 && !c.pinged.After(c.active) && !now.Before(c.active.Add(h.idle.keepalive)):
+		c.pinged = now
+		c.sendEvent(pingEvent{})
+	}
+
+	h.watchIdle(c)
+}
+
+// connected reports whether a client has yet to leave.
+func (h *hub) connected(c *client) bool {
+	for _, other := range h.clients {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
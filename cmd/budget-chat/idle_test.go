@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestNameTimeout(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.idle.unnamed = 50 * time.Millisecond
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	serveTestConns(s, serverConn)
+
+	scanner := bufio.NewScanner(clientConn)
+	m.assert(scanner, "Welcome to budgetchat! What shall I call you?")
+	m.assert(scanner, "* You took too long to give a name")
+	m.is.True(!scanner.Scan()) // disconnected
+}
+
+func TestIdleTimeout(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.idle.named = 200 * time.Millisecond
+	s.hub.idle.keepalive = 50 * time.Millisecond
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	serveTestConns(s, aliceServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	join(m, aliceClientConn, alice, "Alice", "")
+
+	// pinged once while quiet, and given longer by saying anything
+	m.assert(alice, "* ping")
+	aliceClientConn.Write([]byte("/names\n"))
+	m.assert(alice, "* The room contains: Alice")
+
+	m.assert(alice, "* ping")
+	m.assert(alice, "* Disconnected after 200ms idle")
+	m.is.True(!alice.Scan()) // disconnected
+}
+
+func TestIRCPing(t *testing.T) {
+	is := is.New(t)
+	is.Equal((&ircProtocol{}).format(pingEvent{}), "PING :budgetchat\r\n")
+	is.Equal(lineProtocol{}.format(pingEvent{}), "* ping\n")
+}
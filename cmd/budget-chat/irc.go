@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ircServerName prefixes the replies that come from the server itself.
+const ircServerName = "budgetchat"
+
+// ircProtocol speaks enough of IRC (RFC 2812) for IRC clients to chat
+// alongside budget-chat clients. Each room is a channel named after it with a
+// leading '#'.
+type ircProtocol struct {
+	nick     string // "" until registered
+	welcomed bool
+	sync.Mutex
+}
+
+func (p *ircProtocol) setNick(nick string) {
+	p.Lock()
+	defer p.Unlock()
+	p.nick = nick
+}
+
+// target is who replies are addressed to, "*" before registration.
+func (p *ircProtocol) target() string {
+	p.Lock()
+	defer p.Unlock()
+	if p.nick == "" {
+		return "*"
+	}
+	return p.nick
+}
+
+// ircUnsafe replaces what would end an IRC line early in text from other
+// clients, which could otherwise forge lines of its own.
+var ircUnsafe = strings.NewReplacer("\r", " ", "\n", " ", "\x00", "")
+
+func ircText(text string) string {
+	return ircUnsafe.Replace(text)
+}
+
+func ircPrefix(nick string) string {
+	nick = ircText(nick)
+	return nick + "!" + nick + "@" + ircServerName
+}
+
+func ircChannel(room string) string {
+	return "#" + ircText(room)
+}
+
+// reply formats a numeric reply.
+func (p *ircProtocol) reply(code string, params string) string {
+	return fmt.Sprintf(":%s %s %s %s\r\n", ircServerName, code, p.target(), params)
+}
+
+func (p *ircProtocol) format(e event) string {
+	switch e := e.(type) {
+	case chatEvent:
+		return fmt.Sprintf(":%s PRIVMSG %s :%s\r\n", ircPrefix(e.from), ircChannel(e.room), ircText(e.text))
+	case emoteEvent:
+		return fmt.Sprintf(":%s PRIVMSG %s :\x01ACTION %s\x01\r\n", ircPrefix(e.from), ircChannel(e.room), ircText(e.action))
+	case privateEvent:
+		// IRC clients show what they send themselves
+		if e.from == p.target() && e.to != e.from {
+			return ""
+		}
+		return fmt.Sprintf(":%s PRIVMSG %s :%s\r\n", ircPrefix(e.from), ircText(e.to), ircText(e.text))
+	case enterEvent:
+		return fmt.Sprintf(":%s JOIN %s\r\n", ircPrefix(e.name), ircChannel(e.room))
+	case nickEvent:
+		if e.from == p.target() {
+			p.setNick(e.to)
+		}
+		return fmt.Sprintf(":%s NICK :%s\r\n", ircPrefix(e.from), ircText(e.to))
+	case leaveEvent:
+		if e.quit {
+			return fmt.Sprintf(":%s QUIT :Quit\r\n", ircPrefix(e.name))
+		}
+		return fmt.Sprintf(":%s PART %s\r\n", ircPrefix(e.name), ircChannel(e.room))
+	case namesEvent:
+		return p.formatNames(e)
+	case pingEvent:
+		return fmt.Sprintf("PING :%s\r\n", ircServerName)
+	case topicEvent:
+		if e.setBy != "" {
+			return fmt.Sprintf(":%s TOPIC %s :%s\r\n", ircPrefix(e.setBy), ircChannel(e.room), ircText(e.topic))
+		}
+		return p.reply("332", fmt.Sprintf("%s :%s", ircChannel(e.room), ircText(e.topic)))
+	}
+	return p.notice(e.line())
+}
+
+// formatNames answers NAMES. On entering a room it also confirms the JOIN,
+// and completes registration the first time.
+func (p *ircProtocol) formatNames(e namesEvent) string {
+	nick := p.target()
+	names := e.names
+
+	var b strings.Builder
+	if e.entered {
+		p.Lock()
+		if !p.welcomed {
+			p.welcomed = true
+			p.Unlock()
+			b.WriteString(p.reply("001", fmt.Sprintf(":Welcome to budgetchat, %s", nick)))
+			b.WriteString(p.reply("422", ":MOTD File is missing"))
+		} else {
+			p.Unlock()
+		}
+
+		fmt.Fprintf(&b, ":%s JOIN %s\r\n", ircPrefix(nick), ircChannel(e.room))
+		// the names are of the other members
+		names = append(append([]string(nil), names...), nick)
+	}
+
+	b.WriteString(p.reply("353", fmt.Sprintf("= %s :%s", ircChannel(e.room), ircText(strings.Join(names, " ")))))
+	b.WriteString(p.reply("366", fmt.Sprintf("%s :End of /NAMES list", ircChannel(e.room))))
+	return b.String()
+}
+
+func (p *ircProtocol) notice(text string) string {
+	target := p.target()
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Fprintf(&b, ":%s NOTICE %s :%s\r\n", ircServerName, target, ircText(line))
+	}
+	return b.String()
+}
+
+// parseIRCMessage splits a line into its command, in upper case, and
+// parameters. Any prefix is ignored, as clients may not speak for others.
+func parseIRCMessage(line string) (string, []string) {
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	params := fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+// redactIRC hides the passwords in a line, so that it can be logged. Messages
+// are redacted like budget-chat's lines, and those to services in full.
+func redactIRC(line string) string {
+	cmd, params := parseIRCMessage(line)
+	switch {
+	case cmd == "PASS" || cmd == "OPER":
+		return cmd + " [redacted]"
+	case cmd == "PRIVMSG" && len(params) == 2 && strings.HasSuffix(strings.ToUpper(params[0]), "SERV"):
+		return fmt.Sprintf("%s %s :[redacted]", cmd, params[0])
+	case cmd == "PRIVMSG" && len(params) == 2 && redact(params[1]) != params[1]:
+		return fmt.Sprintf("%s %s :%s", cmd, params[0], redact(params[1]))
+	}
+	return line
+}
+
+// StartIRC accepts IRC clients on a port of their own.
+func (s *ChatServer) StartIRC(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	log.Println("listening for IRC on port", port)
+
+	return s.accept(l, func() protocol { return &ircProtocol{} }, s.serveIRC)
+}
+
+// serveIRC maps the IRC commands of a client onto the chat. Registration with
+// NICK and USER names the client, which then joins the default room like any
+// other. A registered nickname needs its password sent with PASS first.
+func (s *ChatServer) serveIRC(client *client) error {
+	defer s.remove(client)
+
+	p := client.proto.(*ircProtocol)
+
+	var nick, pass string
+	var user, joined bool
+
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		log.Println("received:", redactIRC(line))
+
+		cmd, params := parseIRCMessage(line)
+
+		switch cmd {
+		case "":
+			continue
+		case "PING":
+			client.queue(fmt.Sprintf(":%s PONG %s :%s\r\n", ircServerName, ircServerName, strings.Join(params, " ")))
+			continue
+		case "PONG":
+			if joined {
+				s.hub.touch(client)
+			}
+			continue
+		case "CAP":
+			continue
+		case "QUIT":
+			return nil
+		case "PASS":
+			if joined {
+				client.queue(p.reply("462", ":You may not reregister"))
+			} else if len(params) > 0 {
+				pass = params[0]
+			}
+			continue
+		case "NICK":
+			if len(params) == 0 {
+				client.queue(p.reply("431", ":No nickname given"))
+			} else if joined {
+				s.hub.command(client, "nick", params[0])
+			} else {
+				nick = s.hub.namePolicy.normalize(params[0])
+			}
+		case "USER":
+			if joined {
+				client.queue(p.reply("462", ":You may not reregister"))
+			}
+			user = true
+		default:
+			if !joined {
+				client.queue(p.reply("451", ":You have not registered"))
+			} else {
+				s.ircCommand(client, p, cmd, params)
+			}
+			continue
+		}
+
+		if joined || nick == "" || !user {
+			continue
+		}
+
+		// replies sent while joining are addressed to the new nick
+		p.setNick(nick)
+		switch err := s.nameClient(client, nick, pass); {
+		case errors.Is(err, errInvalidName), errors.Is(err, errNameReserved):
+			p.setNick("")
+			client.queue(p.reply("432", fmt.Sprintf("%s :Erroneous nickname", nick)))
+			nick = ""
+		case errors.Is(err, errNameTaken):
+			p.setNick("")
+			client.queue(p.reply("433", fmt.Sprintf("%s :Nickname is already in use", nick)))
+			nick = ""
+		case errors.Is(err, errNameRegistered):
+			p.setNick("")
+			client.queue(p.reply("433", fmt.Sprintf("%s :Nickname is registered, send PASS first", nick)))
+			nick = ""
+		case errors.Is(err, errIncorrectPassword):
+			p.setNick("")
+			client.queue(p.reply("464", ":Password incorrect"))
+			nick = ""
+		default:
+			joined = true
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ircNickServ is where IRC clients send the commands that register and
+// identify nicknames.
+const ircNickServ = "NickServ"
+
+// nickServ runs the REGISTER and IDENTIFY commands sent to NickServ, e.g.
+// "IDENTIFY <password>", as /register and /identify.
+func (s *ChatServer) nickServ(client *client, text string) {
+	cmd, password, _ := cutArg(text)
+	switch strings.ToLower(cmd) {
+	case "register", "identify":
+		s.hub.command(client, strings.ToLower(cmd), password)
+	default:
+		client.send("* NickServ knows REGISTER <password> and IDENTIFY <password>\n")
+	}
+}
+
+// ircCommand runs a command from a registered IRC client through the same
+// paths as budget-chat's lines and slash commands.
+func (s *ChatServer) ircCommand(client *client, p *ircProtocol, cmd string, params []string) {
+	switch cmd {
+	case "JOIN":
+		if len(params) == 0 {
+			client.queue(p.reply("461", "JOIN :Not enough parameters"))
+			return
+		}
+		// only one room at a time, so only the first channel is joined
+		channel, _, _ := strings.Cut(params[0], ",")
+		if !strings.HasPrefix(channel, "#") {
+			client.queue(p.reply("403", fmt.Sprintf("%s :No such channel", channel)))
+			return
+		}
+		s.hub.command(client, "join", channel[1:])
+	case "PART":
+		s.hub.command(client, "leave", "")
+	case "NAMES":
+		s.hub.command(client, "names", "")
+	case "TOPIC":
+		topic := ""
+		if len(params) > 1 {
+			topic = params[1]
+		}
+		s.hub.command(client, "topic", topic)
+	case "PRIVMSG":
+		if len(params) == 0 {
+			client.queue(p.reply("411", ":No recipient given (PRIVMSG)"))
+			return
+		}
+		if len(params) < 2 || params[1] == "" {
+			client.queue(p.reply("412", ":No text to send"))
+			return
+		}
+
+		target, text := params[0], params[1]
+		if strings.EqualFold(target, ircNickServ) {
+			s.nickServ(client, text)
+			return
+		}
+		if strings.HasPrefix(text, "\x01ACTION ") {
+			action := strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+			if strings.HasPrefix(target, "#") {
+				s.hub.command(client, "me", action)
+			} else {
+				s.hub.command(client, "msg", target+" * "+action)
+			}
+			return
+		}
+
+		// a client is only ever in one room, so messages to a channel go there
+		if strings.HasPrefix(target, "#") {
+			s.hub.message(client, text)
+		} else {
+			s.hub.command(client, "msg", target+" "+text)
+		}
+	case "MODE", "WHO":
+		// sent by clients on joining, but rooms have no modes to report
+	default:
+		client.queue(p.reply("421", fmt.Sprintf("%s :Unknown command", cmd)))
+	}
+}
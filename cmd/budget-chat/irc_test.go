@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func serveIRCConns(s *ChatServer, conns ...net.Conn) {
+	for _, conn := range conns {
+		client, err := s.connect(conn, &ircProtocol{})
+		if err != nil {
+			continue
+		}
+		go func() {
+			s.serveIRC(client)
+		}()
+	}
+}
+
+func TestIRCClients(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveIRCConns(s, aliceServerConn)
+	serveTestConns(s, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, bobClientConn, bob, "Bob", "")
+
+	tt := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "JOIN #general", expected: []string{":budgetchat 451 * :You have not registered"}},
+		{input: "NICK b@d"},
+		{input: "USER alice 0 * :Alice", expected: []string{":budgetchat 432 * b@d :Erroneous nickname"}},
+		{input: "NICK Bob", expected: []string{":budgetchat 433 * Bob :Nickname is already in use"}},
+		{input: "NICK Alice", expected: []string{
+			":budgetchat 001 Alice :Welcome to budgetchat, Alice",
+			":budgetchat 422 Alice :MOTD File is missing",
+			":Alice!Alice@budgetchat JOIN #general",
+			":budgetchat 353 Alice = #general :Bob Alice",
+			":budgetchat 366 Alice #general :End of /NAMES list",
+		}},
+	}
+
+	for _, tc := range tt {
+		aliceClientConn.Write([]byte(tc.input + "\r\n"))
+		for _, expected := range tc.expected {
+			m.assert(alice, expected)
+		}
+	}
+
+	m.assert(bob, "* Alice has entered the room")
+
+	// the room is shared both ways
+	aliceClientConn.Write([]byte("PRIVMSG #general :Hi Bob\r\n"))
+	m.assert(bob, "[Alice] Hi Bob")
+
+	bobClientConn.Write([]byte("Hi Alice\n"))
+	m.assert(alice, ":Bob!Bob@budgetchat PRIVMSG #general :Hi Alice")
+
+	aliceClientConn.Write([]byte("PRIVMSG #general :\x01ACTION waves\x01\r\n"))
+	m.assert(bob, "* Alice waves")
+
+	bobClientConn.Write([]byte("/me waves back\n"))
+	m.assert(alice, ":Bob!Bob@budgetchat PRIVMSG #general :\x01ACTION waves back\x01")
+
+	// private messages are not echoed to IRC senders
+	aliceClientConn.Write([]byte("PRIVMSG Bob :Psst\r\n"))
+	m.assert(bob, "[Alice -> Bob] Psst")
+
+	bobClientConn.Write([]byte("/msg Alice What?\n"))
+	m.assert(bob, "[Bob -> Alice] What?")
+	m.assert(alice, ":Bob!Bob@budgetchat PRIVMSG Alice :What?")
+
+	aliceClientConn.Write([]byte("NAMES #general\r\n"))
+	m.assert(alice, ":budgetchat 353 Alice = #general :Bob Alice")
+	m.assert(alice, ":budgetchat 366 Alice #general :End of /NAMES list")
+
+	aliceClientConn.Write([]byte("PING :12345\r\n"))
+	m.assert(alice, ":budgetchat PONG budgetchat :12345")
+
+	aliceClientConn.Write([]byte("KNOCK #general\r\n"))
+	m.assert(alice, ":budgetchat 421 Alice KNOCK :Unknown command")
+
+	// notices from the server
+	aliceClientConn.Write([]byte("TOPIC #general\r\n"))
+	m.assert(alice, ":budgetchat NOTICE Alice :* No topic is set for general")
+
+	bobClientConn.Write([]byte("/topic Testing\n"))
+	m.assert(bob, "* Bob set the topic to: Testing")
+	m.assert(alice, ":Bob!Bob@budgetchat TOPIC #general :Testing")
+
+	// presence across rooms
+	bobClientConn.Write([]byte("/join dev\n"))
+	m.assert(bob, "* The room contains: ")
+	m.assert(alice, ":Bob!Bob@budgetchat PART #general")
+
+	aliceClientConn.Write([]byte("JOIN #dev\r\n"))
+	m.assert(bob, "* Alice has entered the room")
+	m.assert(alice, ":Alice!Alice@budgetchat JOIN #dev")
+	m.assert(alice, ":budgetchat 353 Alice = #dev :Bob Alice")
+	m.assert(alice, ":budgetchat 366 Alice #dev :End of /NAMES list")
+}
+
+func TestIRCQuit(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveIRCConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	register := func(conn net.Conn, scn *bufio.Scanner, nick string, names string) {
+		conn.Write([]byte("NICK " + nick + "\r\nUSER " + nick + " 0 * :" + nick + "\r\n"))
+		m.assert(scn, ":budgetchat 001 "+nick+" :Welcome to budgetchat, "+nick)
+		m.assert(scn, ":budgetchat 422 "+nick+" :MOTD File is missing")
+		m.assert(scn, ":"+nick+"!"+nick+"@budgetchat JOIN #general")
+		m.assert(scn, ":budgetchat 353 "+nick+" = #general :"+names)
+		m.assert(scn, ":budgetchat 366 "+nick+" #general :End of /NAMES list")
+	}
+
+	register(aliceClientConn, alice, "Alice", "Alice")
+	register(bobClientConn, bob, "Bob", "Alice Bob")
+	m.assert(alice, ":Bob!Bob@budgetchat JOIN #general")
+
+	// NICK after registration changes the name
+	aliceClientConn.Write([]byte("NICK Alicia\r\n"))
+	m.assert(alice, ":Alice!Alice@budgetchat NICK :Alicia")
+	m.assert(bob, ":Alice!Alice@budgetchat NICK :Alicia")
+
+	aliceClientConn.Write([]byte("NAMES\r\n"))
+	m.assert(alice, ":budgetchat 353 Alicia = #general :Alicia Bob")
+	m.assert(alice, ":budgetchat 366 Alicia #general :End of /NAMES list")
+
+	bobClientConn.Write([]byte("QUIT :Bye\r\n"))
+	m.is.True(!bob.Scan()) // closed
+	m.assert(alice, ":Bob!Bob@budgetchat QUIT :Quit")
+}
+
+func TestIRCFormatUnsafe(t *testing.T) {
+	p := &ircProtocol{nick: "Bob"}
+
+	tt := []struct {
+		name     string
+		e        event
+		expected string
+	}{
+		{
+			name:     "Message",
+			e:        chatEvent{from: "Alice", room: "general", text: "Hi\r:Alice!Alice@budgetchat KICK #general Bob\x00"},
+			expected: ":Alice!Alice@budgetchat PRIVMSG #general :Hi :Alice!Alice@budgetchat KICK #general Bob\r\n",
+		},
+		{
+			name:     "Action",
+			e:        emoteEvent{from: "Alice", room: "general", action: "waves\r\nQUIT"},
+			expected: ":Alice!Alice@budgetchat PRIVMSG #general :\x01ACTION waves  QUIT\x01\r\n",
+		},
+		{
+			name:     "Topic",
+			e:        topicEvent{room: "general", topic: "News\rQUIT"},
+			expected: ":budgetchat 332 Bob #general :News QUIT\r\n",
+		},
+		{
+			name:     "Private Message",
+			e:        privateEvent{from: "Alice", to: "Bob", text: "psst\rQUIT"},
+			expected: ":Alice!Alice@budgetchat PRIVMSG Bob :psst QUIT\r\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(p.format(tc.e), tc.expected)
+		})
+	}
+
+	is := is.New(t)
+	is.Equal(p.notice("* Topic is\r\x00QUIT\n"), ":budgetchat NOTICE Bob :* Topic is QUIT\r\n")
+}
+
+func TestParseIRCMessage(t *testing.T) {
+	tt := []struct {
+		line       string
+		wantCmd    string
+		wantParams []string
+	}{
+		{line: "NICK Alice", wantCmd: "NICK", wantParams: []string{"Alice"}},
+		{line: "privmsg #general :Hello there :)", wantCmd: "PRIVMSG", wantParams: []string{"#general", "Hello there :)"}},
+		{line: ":Alice!a@host PRIVMSG Bob :Hi", wantCmd: "PRIVMSG", wantParams: []string{"Bob", "Hi"}},
+		{line: "USER alice 0 *  :Alice Smith", wantCmd: "USER", wantParams: []string{"alice", "0", "*", "Alice Smith"}},
+		{line: "QUIT", wantCmd: "QUIT"},
+		{line: "PRIVMSG #general :", wantCmd: "PRIVMSG", wantParams: []string{"#general", ""}},
+		{line: "   ", wantCmd: ""},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.line, func(t *testing.T) {
+			is := is.New(t)
+
+			cmd, params := parseIRCMessage(tc.line)
+			is.Equal(cmd, tc.wantCmd)
+			is.Equal(len(params), len(tc.wantParams))
+			for i := range params {
+				is.Equal(params[i], tc.wantParams[i])
+			}
+		})
+	}
+}
+
+func TestRedactIRC(t *testing.T) {
+	tt := []struct {
+		line     string
+		expected string
+	}{
+		{line: "OPER admin secret", expected: "OPER [redacted]"},
+		{line: "pass secret", expected: "PASS [redacted]"},
+		{line: "PRIVMSG NickServ :IDENTIFY secret", expected: "PRIVMSG NickServ :[redacted]"},
+		{line: "PRIVMSG #general :/register secret", expected: "PRIVMSG #general :/register [redacted]"},
+		{line: "PRIVMSG #general :Hello", expected: "PRIVMSG #general :Hello"},
+		{line: "NICK Alice", expected: "NICK Alice"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.line, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(redactIRC(tc.line), tc.expected)
+		})
+	}
+}
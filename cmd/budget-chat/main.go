@@ -2,35 +2,171 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
 	"strings"
-	"sync"
+	"time"
+
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+var (
+	errInvalidName       = errors.New("invalid name")
+	errNameTaken         = errors.New("name taken")
+	errNameReserved      = errors.New("name reserved")
+	errNameRegistered    = errors.New("name registered")
+	errIncorrectPassword = errors.New("incorrect password")
 )
 
 func main() {
 	s := NewChatServer(5000)
+	flag.IntVar(&s.queueSize, "queue-size", defaultQueueSize, "Messages queued for a client before it is disconnected as too slow")
+	flag.IntVar(&s.hub.history.lines, "history", 0, "Recent lines per room replayed to clients joining it (unlimited when 0, disabled when -history-age is 0 too)")
+	flag.DurationVar(&s.hub.history.age, "history-age", 0, "Only keep and replay lines said within this long, e.g. 15m (unlimited when 0, disabled when -history is 0 too)")
+	flag.Float64Var(&s.hub.flood.messageRate, "flood-lines", 0, "Lines per second a client may send on average (unlimited when 0)")
+	flag.IntVar(&s.hub.flood.messageBurst, "flood-line-burst", 0, "Lines a client may send at once (a second's worth when 0)")
+	flag.Float64Var(&s.hub.flood.byteRate, "flood-bytes", 0, "Bytes per second a client may send on average (unlimited when 0)")
+	flag.IntVar(&s.hub.flood.byteBurst, "flood-byte-burst", 0, "Bytes a client may send at once (a second's worth when 0)")
+	flag.IntVar(&s.hub.flood.maxLength, "max-length", 0, "Longest line in bytes a client may send (unlimited when 0)")
+	flag.IntVar(&s.hub.flood.warnings, "flood-warnings", 0, "Warnings before a flooding client is muted")
+	flag.IntVar(&s.hub.flood.mutes, "flood-mutes", 0, "Mutes before a flooding client is disconnected")
+	flag.DurationVar(&s.hub.flood.muteFor, "mute-for", 0, "How long a flooding client is muted (never muted when 0)")
+	flag.StringVar(&s.hub.operPassword, "oper-password", "", "Password for /oper (operators are disabled when empty)")
+	bansFile := flag.String("bans-file", "", "File to keep bans in across restarts (bans are forgotten when empty)")
+	wsPort := flag.Int("ws-port", 0, "Port for the optional WebSocket listener (disabled when 0)")
+	ircPort := flag.Int("irc-port", 0, "Port for the optional IRC listener (disabled when 0)")
+	transcriptFile := flag.String("transcript", "", "File to log joins, leaves and messages to, which also backs -history (disabled when empty)")
+	transcriptMaxBytes := flag.Int64("transcript-max-bytes", 0, "Size at which the transcript is rotated (unlimited when 0)")
+	transcriptDaily := flag.Bool("transcript-daily", false, "Rotate the transcript at the start of each day (UTC)")
+	nicksFile := flag.String("nicks-file", "", "File to keep registered names in across restarts (registrations are forgotten when empty)")
+	flag.IntVar(&s.hub.namePolicy.maxLength, "name-max-length", 0, "Longest name in characters a client or room may have (unlimited when 0)")
+	flag.BoolVar(&s.hub.namePolicy.unicode, "name-unicode", false, "Allow letters and digits of any script in names, not just ASCII")
+	flag.StringVar(&s.hub.namePolicy.extra, "name-chars", "", "Characters allowed in names besides letters and digits, e.g. _-")
+	flag.BoolVar(&s.hub.namePolicy.foldCase, "name-fold-case", false, "Treat names differing only in case as the same name")
+	reservedNames := flag.String("reserved-names", "", "Comma-separated names no client may take, in any case")
+	maskWords := flag.String("mask-words", "", "Comma-separated words masked with asterisks in messages")
+	botName := flag.String("bot", "", "Name of a bot answering !time and !help in every room (disabled when empty)")
+	serverName := flag.String("server-name", hostname(), "Name of this server to linked servers, shown after the names of its users")
+	linkPort := flag.Int("link-port", 0, "Port for links from peer servers (disabled when 0)")
+	peers := flag.String("peers", "", "Comma-separated addresses of peer servers to link to")
+	peerRetry := flag.Duration("peer-retry", defaultPeerRetry, "How long to wait before relinking to a peer")
+	flag.DurationVar(&s.hub.idle.unnamed, "name-timeout", 0, "How long a client has to give a name (unlimited when 0)")
+	flag.DurationVar(&s.hub.idle.named, "idle-timeout", 0, "How long a named client may say nothing before it is disconnected (unlimited when 0)")
+	flag.DurationVar(&s.hub.idle.keepalive, "keepalive", 0, "How long a named client may say nothing before it is pinged (never when 0)")
+	flag.DurationVar(&s.hub.resumeGrace, "resume-grace", 0, "How long a client that drops may resume with its token (disabled when 0)")
+	flag.Parse()
+
+	if !validServerName(*serverName) {
+		log.Fatalf("invalid server name: %q", *serverName)
+	}
+	s.hub.serverName = *serverName
+
+	s.hub.namePolicy.reserved = splitList(*reservedNames)
+
+	if words := splitList(*maskWords); len(words) > 0 {
+		s.hub.filters = append(s.hub.filters, rewriterFilter{newWordMasker(words)})
+	}
+
+	if *botName != "" {
+		// bots are said to be in the room, so clients may not pose as them
+		s.hub.filters = append(s.hub.filters, newTimeBot(*botName, s.hub.now))
+		s.hub.namePolicy.reserved = append(s.hub.namePolicy.reserved, *botName)
+	}
+
+	if *bansFile != "" {
+		bans, err := loadBanList(*bansFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.hub.bans = bans
+	}
+
+	if *nicksFile != "" {
+		nicks, err := loadNickStore(*nicksFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.hub.nicks = nicks
+	}
+	s.hub.nicks.foldCase = s.hub.namePolicy.foldCase
+
+	if *transcriptFile != "" {
+		l, err := transcript.Open(*transcriptFile, *transcriptMaxBytes, *transcriptDaily)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.hub.transcript = l
+		s.hub.restoreHistory()
+	}
+
+	if *wsPort != 0 {
+		go func() {
+			log.Println("listening for WebSockets on port", *wsPort)
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *wsPort), s.webSocketHandler()))
+		}()
+	}
+
+	if *ircPort != 0 {
+		go func() {
+			log.Fatal(s.StartIRC(*ircPort))
+		}()
+	}
+
+	if *linkPort != 0 {
+		go func() {
+			log.Fatal(s.StartFederation(*linkPort))
+		}()
+	}
+
+	for _, addr := range splitList(*peers) {
+		go s.Peer(addr, *peerRetry)
+	}
+
 	log.Fatal(s.Start())
 }
 
-type ChatServer struct {
-	port     int
-	clients  []*client
-	listener net.Listener
-	sync.Mutex
+// splitList splits a comma-separated flag, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
-type client struct {
-	name string
-	addr string
-	conn net.Conn
+// hostname names the server after its host, where it can.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || !validServerName(name) {
+		return defaultServerName
+	}
+	return name
+}
+
+type ChatServer struct {
+	port      int
+	hub       *hub
+	listener  net.Listener
+	queueSize int
 }
 
 func NewChatServer(port int) *ChatServer {
-	return &ChatServer{
-		port: port,
+	s := &ChatServer{
+		port:      port,
+		hub:       newHub(),
+		queueSize: defaultQueueSize,
 	}
+
+	go s.hub.run()
+
+	return s
 }
 
 func (s *ChatServer) Start() error {
@@ -42,16 +178,26 @@ func (s *ChatServer) Start() error {
 
 	log.Println("listening on port", s.port)
 
+	return s.accept(s.listener, func() protocol { return lineProtocol{} }, s.serve)
+}
+
+// accept serves each connection to a listener as a client speaking the given
+// protocol.
+func (s *ChatServer) accept(l net.Listener, newProtocol func() protocol, serve func(*client) error) error {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
 			return fmt.Errorf("accept: %w", err)
 		}
 
-		client := s.connect(conn)
+		client, err := s.connect(conn, newProtocol())
+		if err != nil {
+			log.Printf("connection from %s refused: %s\n", conn.RemoteAddr(), err)
+			continue
+		}
 
 		go func() {
-			if err := s.serve(client); err != nil {
+			if err := serve(client); err != nil {
 				fmt.Println(err.Error())
 			}
 		}()
@@ -64,142 +210,108 @@ func (s *ChatServer) Close() {
 	}
 }
 
-func (s *ChatServer) connect(conn net.Conn) *client {
-	client := &client{
-		addr: conn.RemoteAddr().String(),
-		conn: conn,
+// connect adds a new connection to the chat, turning it away if its address
+// is banned.
+func (s *ChatServer) connect(conn net.Conn, proto protocol) (*client, error) {
+	if s.hub.bans.banned(remoteIP(conn), s.hub.now()) {
+		// refuse in the background so a slow reader cannot hold up accepting
+		go func() {
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			conn.Write([]byte(proto.notice("* You are banned\n")))
+			conn.Close()
+		}()
+		return nil, errBanned
 	}
 
-	s.Lock()
-	s.clients = append(s.clients, client)
-	s.Unlock()
-
-	log.Printf("connection from %s [# connected clients: %d]\n", client.addr, len(s.clients))
-
-	return client
+	client := newClient(conn, s.queueSize, proto)
+	s.hub.connect(client)
+	return client, nil
 }
 
 func (s *ChatServer) remove(client *client) {
-	s.Lock()
-	for i, c := range s.clients {
-		if client == c {
-			s.clients[i] = s.clients[len(s.clients)-1]
-			s.clients = s.clients[:len(s.clients)-1]
-			break
-		}
-	}
-	s.Unlock()
-
-	if client.name != "" {
-		s.broadcast(client, fmt.Sprintf("* %s has left the room\n", client.name))
-	}
-
-	log.Printf("connection from %s closed [# connected clients: %d]\n", client.addr, len(s.clients))
-
-	client.conn.Close()
+	s.hub.leave(client)
+	client.close()
 }
 
 func (s *ChatServer) serve(client *client) error {
 	defer s.remove(client)
 
-	if _, err := client.conn.Write([]byte("Welcome to budgetchat! What shall I call you?\n")); err != nil {
-		return fmt.Errorf("welcome: %w", err)
-	}
+	client.send("Welcome to budgetchat! What shall I call you?\n")
+
+	joined := false
+	registered := "" // a registered name given, to /identify as
 
 	scanner := bufio.NewScanner(client.conn)
 	for scanner.Scan() {
 		line := string(scanner.Bytes())
 
-		log.Println("received:", line)
+		log.Println("received:", redact(line))
 
-		if client.name == "" {
-			if err := s.nameClient(client, line); err != nil {
-				return err
+		if !joined && s.hub.resumeGrace > 0 && strings.HasPrefix(line, "/resume ") {
+			if joined = s.hub.resume(client, strings.TrimPrefix(line, "/resume ")); !joined {
+				client.send("* Unknown resume token\n")
 			}
-		} else {
-			if err := s.broadcast(client, fmt.Sprintf("[%s] %s\n", client.name, line)); err != nil {
-				return fmt.Errorf("broadcast: %w", err)
+		} else if !joined && registered != "" && strings.HasPrefix(line, "/identify ") {
+			switch err := s.nameClient(client, registered, strings.TrimPrefix(line, "/identify ")); {
+			case errors.Is(err, errIncorrectPassword):
+				client.send("* Incorrect password\n")
+			case err != nil:
+				msg := fmt.Sprintf("invalid name: %s\n", registered)
+				client.send(msg)
+				return fmt.Errorf(msg)
+			default:
+				joined = true
+			}
+		} else if !joined {
+			switch err := s.nameClient(client, line, ""); {
+			case errors.Is(err, errNameRegistered):
+				registered = s.hub.namePolicy.normalize(line)
+				client.send(fmt.Sprintf("* %s is registered, /identify <password> to use it or give another name\n", registered))
+			case err != nil:
+				msg := fmt.Sprintf("invalid name: %s\n", line)
+				client.send(msg)
+				return fmt.Errorf(msg)
+			default:
+				joined = true
 			}
+		} else if name, arg, ok := parseCommand(line); ok {
+			s.hub.command(client, name, arg)
+		} else {
+			s.hub.message(client, line)
 		}
 	}
 
 	return scanner.Err()
 }
 
-func (s *ChatServer) nameClient(client *client, name string) error {
-	if s.validateClientName(name) {
-		client.name = name
-
-		if err := s.broadcast(client, fmt.Sprintf("* %s has entered the room\n", client.name)); err != nil {
-			return fmt.Errorf("broadcast: %w", err)
-		}
-
-		roomMsg := fmt.Sprintf("* The room contains: %s\n", s.userNamesPresent(client))
+// nameClient joins a client to the chat under a name, which must be valid,
+// not reserved and not taken. A registered name also needs its password.
+func (s *ChatServer) nameClient(client *client, name string, password string) error {
+	policy := s.hub.namePolicy
 
-		if _, err := client.conn.Write([]byte(roomMsg)); err != nil {
-			return fmt.Errorf("room: %w", err)
-		}
-	} else {
-		invalidNameMsg := fmt.Sprintf("invalid name: %s\n", name)
-		client.conn.Write([]byte(invalidNameMsg))
-		return fmt.Errorf(invalidNameMsg)
+	name = policy.normalize(name)
+	if !policy.valid(name) {
+		return errInvalidName
 	}
-
-	return nil
-}
-
-func (s *ChatServer) validateClientName(name string) bool {
-	// must contain at least one character
-	if len(name) < 1 {
-		return false
+	if policy.isReserved(name) {
+		return errNameReserved
 	}
 
-	// must contain only alphanumeric characters
-	for _, r := range strings.ToLower(name) {
-		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
-			return false
+	identified := false
+	if s.hub.nicks.registered(name) {
+		if password == "" {
+			return errNameRegistered
 		}
-	}
-
-	// must be a unique name
-	for _, client := range s.clients {
-		if name == client.name {
-			return false
-		}
-	}
-
-	return true
-}
-
-func (s *ChatServer) userNamesPresent(client *client) string {
-	s.Lock()
-	defer s.Unlock()
-
-	var names []string
-	for _, c := range s.clients {
-		// do not include self or unnamed clients
-		if client == c || c.name == "" {
-			continue
+		// checked here rather than by the hub, as it is slow by design
+		if !s.hub.nicks.check(name, password) {
+			log.Printf("failed /identify as %s from %s\n", name, client.addr)
+			return errIncorrectPassword
 		}
-		names = append(names, c.name)
+		identified = true
 	}
 
-	return strings.Join(names, ", ")
-}
-
-func (s *ChatServer) broadcast(client *client, msg string) error {
-	s.Lock()
-	defer s.Unlock()
-
-	for _, c := range s.clients {
-		// do not send to self or unnamed clients
-		if client == c || c.name == "" {
-			continue
-		}
-
-		if _, err := c.conn.Write([]byte(msg)); err != nil {
-			return err
-		}
+	if !s.hub.join(client, name, identified) {
+		return errNameTaken
 	}
 
 	return nil
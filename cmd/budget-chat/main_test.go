@@ -2,9 +2,15 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 )
@@ -41,11 +47,30 @@ func (m *messageExpecter) waitAssert(msgAssertions []messageAssertion) {
 	wg.Wait()
 }
 
+// assertUnordered reads as many lines as expected, in any order.
+func (m *messageExpecter) assertUnordered(scn *bufio.Scanner, expected ...string) {
+	got := make([]string, len(expected))
+	for i := range got {
+		scn.Scan()
+		got[i] = scn.Text()
+	}
+
+	want := append([]string(nil), expected...)
+	sort.Strings(got)
+	sort.Strings(want)
+	m.is.Equal(got, want)
+}
+
 func startTestServer(conns ...net.Conn) {
-	s := NewChatServer(5000)
+	serveTestConns(NewChatServer(5000), conns...)
+}
 
+func serveTestConns(s *ChatServer, conns ...net.Conn) {
 	for _, conn := range conns {
-		client := s.connect(conn)
+		client, err := s.connect(conn, lineProtocol{})
+		if err != nil {
+			continue
+		}
 		go func() {
 			s.serve(client)
 		}()
@@ -211,3 +236,474 @@ func TestDuplicateName(t *testing.T) {
 	uniqueClientConn.Close()
 	dupeClientConn.Close()
 }
+
+// join names a client, reading the welcome and room messages.
+func join(m *messageExpecter, conn net.Conn, scn *bufio.Scanner, name string, present string) {
+	m.assert(scn, "Welcome to budgetchat! What shall I call you?")
+	conn.Write([]byte(name + "\n"))
+	m.assert(scn, "* The room contains: "+present)
+}
+
+func TestSlowClientDisconnected(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.queueSize = 2
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	chiekoClientConn, chiekoServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer chiekoClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, chiekoServerConn, bobServerConn)
+
+	aliceClientScanner := bufio.NewScanner(aliceClientConn)
+	chiekoClientScanner := bufio.NewScanner(chiekoClientConn)
+	bobClientScanner := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, aliceClientScanner, "Alice", "")
+	join(m, chiekoClientConn, chiekoClientScanner, "Chieko", "Alice")
+	m.assert(aliceClientScanner, "* Chieko has entered the room")
+
+	// Bob stops reading once he has joined
+	join(m, bobClientConn, bobClientScanner, "Bob", "Alice, Chieko")
+	m.assert(aliceClientScanner, "* Bob has entered the room")
+	m.assert(chiekoClientScanner, "* Bob has entered the room")
+
+	// one message is held by Bob's writer and two fill his queue, so at the
+	// latest the fourth overflows it; Chieko keeps reading and misses nothing
+	left := false
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		aliceClientConn.Write([]byte(msg + "\n"))
+
+		chiekoClientScanner.Scan()
+		if chiekoClientScanner.Text() == "* Bob has left the room" {
+			left = true
+			chiekoClientScanner.Scan()
+		}
+		m.is.Equal(chiekoClientScanner.Text(), "[Alice] "+msg)
+	}
+	if !left {
+		m.assert(chiekoClientScanner, "* Bob has left the room")
+	}
+
+	m.assert(aliceClientScanner, "* Bob has left the room")
+}
+
+// failingConn fails every write once fail is set.
+type failingConn struct {
+	net.Conn
+	fail atomic.Bool
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if c.fail.Load() {
+		return 0, errors.New("write failed")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestFailedWriteIsolated(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	chiekoClientConn, chiekoServerConn := net.Pipe()
+	bobClientConn, bobPipeConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer chiekoClientConn.Close()
+	defer bobClientConn.Close()
+
+	bobServerConn := &failingConn{Conn: bobPipeConn}
+
+	startTestServer(aliceServerConn, chiekoServerConn, bobServerConn)
+
+	aliceClientScanner := bufio.NewScanner(aliceClientConn)
+	chiekoClientScanner := bufio.NewScanner(chiekoClientConn)
+	bobClientScanner := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, aliceClientScanner, "Alice", "")
+	join(m, chiekoClientConn, chiekoClientScanner, "Chieko", "Alice")
+	m.assert(aliceClientScanner, "* Chieko has entered the room")
+	join(m, bobClientConn, bobClientScanner, "Bob", "Alice, Chieko")
+	m.assert(aliceClientScanner, "* Bob has entered the room")
+	m.assert(chiekoClientScanner, "* Bob has entered the room")
+
+	bobServerConn.fail.Store(true)
+
+	aliceClientConn.Write([]byte("Hello\n"))
+
+	// the failed write to Bob disconnects him alone
+	m.assertUnordered(chiekoClientScanner, "[Alice] Hello", "* Bob has left the room")
+	m.assert(aliceClientScanner, "* Bob has left the room")
+
+	// and Alice can carry on talking
+	aliceClientConn.Write([]byte("Still here\n"))
+	m.assert(chiekoClientScanner, "[Alice] Still here")
+}
+
+// joinConcurrently connects a client per name at once, returning whether each
+// was let in. Every client reads all it is sent so that none is disconnected
+// as slow.
+func joinConcurrently(s *ChatServer, names []string) ([]net.Conn, []bool) {
+	conns := make([]net.Conn, len(names))
+	joined := make([]bool, len(names))
+
+	var wg sync.WaitGroup
+	wg.Add(len(names))
+
+	for i, name := range names {
+		clientConn, serverConn := net.Pipe()
+		conns[i] = clientConn
+		serveTestConns(s, serverConn)
+
+		go func(i int, name string) {
+			scn := bufio.NewScanner(conns[i])
+			scn.Scan() // welcome
+			conns[i].Write([]byte(name + "\n"))
+			scn.Scan()
+			joined[i] = strings.HasPrefix(scn.Text(), "* The room contains: ")
+			wg.Done()
+
+			for scn.Scan() {
+			}
+		}(i, name)
+	}
+
+	wg.Wait()
+	return conns, joined
+}
+
+func TestConcurrentJoinsAndLeaves(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	s := NewChatServer(5000)
+
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("user%d", i)
+	}
+
+	conns, joined := joinConcurrently(s, names)
+	for i := range joined {
+		is.True(joined[i]) // every distinct name joins
+	}
+
+	present := s.hub.list()
+	sort.Strings(present)
+	want := append([]string(nil), names...)
+	sort.Strings(want)
+	is.Equal(present, want)
+
+	var wg sync.WaitGroup
+	wg.Add(len(conns))
+	for _, conn := range conns {
+		go func(conn net.Conn) {
+			conn.Close()
+			wg.Done()
+		}(conn)
+	}
+	wg.Wait()
+
+	// leaving is processed by the server after the close returns
+	for i := 0; i < 100 && len(s.hub.list()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.Equal(s.hub.list(), []string{})
+}
+
+func TestConcurrentDuplicateNames(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	s := NewChatServer(5000)
+
+	names := make([]string, 20)
+	for i := range names {
+		names[i] = "Same"
+	}
+
+	conns, joined := joinConcurrently(s, names)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	count := 0
+	for _, ok := range joined {
+		if ok {
+			count++
+		}
+	}
+	is.Equal(count, 1) // only one client gets the name
+	is.Equal(s.hub.list(), []string{"Same"})
+}
+
+func TestSplitList(t *testing.T) {
+	is := is.New(t)
+	is.Equal(splitList(" admin, ,root,"), []string{"admin", "root"})
+	is.Equal(len(splitList("")), 0)
+}
+
+func TestRedact(t *testing.T) {
+	tt := []struct {
+		line     string
+		expected string
+	}{
+		{line: "/oper secret", expected: "/oper [redacted]"},
+		{line: "/register secret", expected: "/register [redacted]"},
+		{line: "/identify  secret", expected: "/identify [redacted]"},
+		{line: "/resume 0123456789abcdef", expected: "/resume [redacted]"},
+		{line: "/oper", expected: "/oper"},
+		{line: "/join dev", expected: "/join dev"},
+		{line: "I forgot my /oper password", expected: "I forgot my /oper password"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.line, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(redact(tc.line), tc.expected)
+		})
+	}
+}
+
+func TestRooms(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	chiekoClientConn, chiekoServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+	defer chiekoClientConn.Close()
+
+	startTestServer(aliceServerConn, bobServerConn, chiekoServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+	chieko := bufio.NewScanner(chiekoClientConn)
+
+	// everyone starts in the default room
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+	join(m, chiekoClientConn, chieko, "Chieko", "Alice, Bob")
+	m.assert(alice, "* Chieko has entered the room")
+	m.assert(bob, "* Chieko has entered the room")
+
+	// Bob moves to a new room
+	bobClientConn.Write([]byte("/join dev\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: bob, expected: "* The room contains: "},
+		{scanner: alice, expected: "* Bob has left the room"},
+		{scanner: chieko, expected: "* Bob has left the room"},
+	})
+
+	// messages stay within a room
+	aliceClientConn.Write([]byte("Where did Bob go?\n"))
+	m.assert(chieko, "[Alice] Where did Bob go?")
+
+	bobClientConn.Write([]byte("/topic Deploys\n"))
+	m.assert(bob, "* Bob set the topic to: Deploys")
+
+	// a newcomer is told the topic
+	chiekoClientConn.Write([]byte("/join dev\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: chieko, expected: "* The room contains: Bob"},
+		{scanner: bob, expected: "* Chieko has entered the room"},
+		{scanner: alice, expected: "* Chieko has left the room"},
+	})
+	m.assert(chieko, "* The topic is: Deploys")
+
+	chiekoClientConn.Write([]byte("/topic\n"))
+	m.assert(chieko, "* The topic for dev is: Deploys")
+
+	chiekoClientConn.Write([]byte("/rooms\n"))
+	m.assert(chieko, "* Rooms: dev (2), general (1)")
+
+	bobClientConn.Write([]byte("Hi Chieko\n"))
+	m.assert(chieko, "[Bob] Hi Chieko")
+
+	// leaving returns to the default room
+	bobClientConn.Write([]byte("/leave\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: bob, expected: "* The room contains: Alice"},
+		{scanner: alice, expected: "* Bob has entered the room"},
+		{scanner: chieko, expected: "* Bob has left the room"},
+	})
+
+	bobClientConn.Write([]byte("/leave\n"))
+	m.assert(bob, "* You are already in the default room")
+
+	// the last member leaving removes the room and its topic
+	chiekoClientConn.Write([]byte("/leave\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: chieko, expected: "* The room contains: Alice, Bob"},
+		{scanner: alice, expected: "* Chieko has entered the room"},
+		{scanner: bob, expected: "* Chieko has entered the room"},
+	})
+
+	chiekoClientConn.Write([]byte("/rooms\n"))
+	m.assert(chieko, "* Rooms: general (3)")
+
+	chiekoClientConn.Write([]byte("/topic\n"))
+	m.assert(chieko, "* No topic is set for general")
+}
+
+func TestJoinRoomErrors(t *testing.T) {
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "Missing Room", input: "/join", expected: "* Usage: /join <room>"},
+		{name: "Invalid Room", input: "/join #dev", expected: "* Invalid room name: #dev"},
+		{name: "Already In Room", input: "/join general", expected: "* You are already in general"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := newMessageExpecter(t)
+
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+
+			startTestServer(serverConn)
+
+			clientScanner := bufio.NewScanner(clientConn)
+			join(m, clientConn, clientScanner, "Alice", "")
+
+			clientConn.Write([]byte(tc.input + "\n"))
+			m.assert(clientScanner, tc.expected)
+		})
+	}
+}
+
+// fakeClock stands in for time.Now, moving only when advanced.
+type fakeClock struct {
+	t time.Time
+	sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestPrivateMessages(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	chiekoClientConn, chiekoServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+	defer chiekoClientConn.Close()
+
+	startTestServer(aliceServerConn, bobServerConn, chiekoServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+	chieko := bufio.NewScanner(chiekoClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+	join(m, chiekoClientConn, chieko, "Chieko", "Alice, Bob")
+	m.assert(alice, "* Chieko has entered the room")
+	m.assert(bob, "* Chieko has entered the room")
+
+	// private messages reach users in other rooms too
+	chiekoClientConn.Write([]byte("/join dev\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: chieko, expected: "* The room contains: "},
+		{scanner: alice, expected: "* Chieko has left the room"},
+		{scanner: bob, expected: "* Chieko has left the room"},
+	})
+
+	aliceClientConn.Write([]byte("/msg Chieko Lunch?\n"))
+	m.waitAssert([]messageAssertion{
+		{scanner: alice, expected: "[Alice -> Chieko] Lunch?"},
+		{scanner: chieko, expected: "[Alice -> Chieko] Lunch?"},
+	})
+
+	// Bob, who shares a room with Alice, sees only what is said to the room
+	aliceClientConn.Write([]byte("Hello room\n"))
+	m.assert(bob, "[Alice] Hello room")
+
+	aliceClientConn.Write([]byte("/me waves\n"))
+	m.assert(bob, "* Alice waves")
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/msg Dave Hi", expected: "* No such user: Dave"},
+		{input: "/msg Chieko", expected: "* Usage: /msg <name> <text>"},
+		{input: "/msg", expected: "* Usage: /msg <name> <text>"},
+		{input: "/me", expected: "* Usage: /me <action>"},
+		{input: "/dance", expected: "* Unknown command: /dance"},
+	}
+
+	for _, tc := range tt {
+		aliceClientConn.Write([]byte(tc.input + "\n"))
+		m.assert(alice, tc.expected)
+	}
+}
+
+func TestWho(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+
+	s := NewChatServer(5000)
+	s.hub.now = clock.Now
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	serveTestConns(s, aliceServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	join(m, aliceClientConn, alice, "Alice", "")
+
+	clock.Advance(90 * time.Second)
+
+	bobClientConn, bobServerConn := net.Pipe()
+	defer bobClientConn.Close()
+	serveTestConns(s, bobServerConn)
+
+	bob := bufio.NewScanner(bobClientConn)
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	clock.Advance(30 * time.Second)
+
+	// an unnamed connection is not listed
+	unnamedClientConn, unnamedServerConn := net.Pipe()
+	defer unnamedClientConn.Close()
+	serveTestConns(s, unnamedServerConn)
+	m.assert(bufio.NewScanner(unnamedClientConn), "Welcome to budgetchat! What shall I call you?")
+
+	bobClientConn.Write([]byte("/who\n"))
+	m.assert(bob, "* Users online: 2")
+	m.assert(bob, "* Alice (general): connected 2m0s, idle 2m0s")
+	m.assert(bob, "* Bob (general): connected 30s, idle 0s")
+}
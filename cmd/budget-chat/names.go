@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// namePolicy decides which names clients and rooms may have. The zero value is
+// budget-chat's original policy, which the protocol checker expects: one or
+// more ASCII letters and digits, of any length, with names differing in case
+// being different names.
+type namePolicy struct {
+	maxLength int      // in characters (unlimited when 0)
+	unicode   bool     // allow letters and digits of any script, not just ASCII
+	extra     string   // other characters allowed, e.g. "_-"
+	foldCase  bool     // names differing only in case are the same name
+	reserved  []string // names no client may take, in any case
+}
+
+// normalize gives the form a name is known by. With Unicode names allowed,
+// names are put in Unicode normalization form NFKC so that each has a single
+// spelling: "Ａlice" cannot pass for "Alice", and "Zoe" with a combining
+// diaeresis is "Zoë". Combining marks left over, with no precomposed letter,
+// are refused by valid.
+func (p namePolicy) normalize(name string) string {
+	if !p.unicode {
+		return name
+	}
+	return norm.NFKC.String(name)
+}
+
+// valid checks the form of a name; whether it is taken is up to the hub.
+func (p namePolicy) valid(name string) bool {
+	// must contain at least one character
+	if name == "" || !utf8.ValidString(name) {
+		return false
+	}
+
+	if p.maxLength > 0 && utf8.RuneCountInString(name) > p.maxLength {
+		return false
+	}
+
+	for _, r := range name {
+		if !p.allowed(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p namePolicy) allowed(r rune) bool {
+	switch {
+	case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+		return true
+	case p.unicode && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+		return true
+	}
+	return strings.ContainsRune(p.extra, r)
+}
+
+// isReserved reports whether a name is kept from clients.
+func (p namePolicy) isReserved(name string) bool {
+	for _, reserved := range p.reserved {
+		if equalFold(name, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether two names are the same name.
+func (p namePolicy) same(a string, b string) bool {
+	if p.foldCase {
+		return equalFold(a, b)
+	}
+	return a == b
+}
+
+// equalFold reports whether two names are the same once case folded. Full
+// Unicode case folding is used, so that e.g. "STRASSE" and "straße" match.
+func equalFold(a string, b string) bool {
+	return cases.Fold().String(a) == cases.Fold().String(b)
+}
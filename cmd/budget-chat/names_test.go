@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestNamePolicy(t *testing.T) {
+	custom := namePolicy{maxLength: 5, unicode: true, extra: "_-", reserved: []string{"admin"}}
+
+	tt := []struct {
+		name     string
+		policy   namePolicy
+		input    string
+		valid    bool
+		reserved bool
+	}{
+		{name: "Default ASCII", policy: namePolicy{}, input: "Alice99", valid: true},
+		{name: "Default Long", policy: namePolicy{}, input: "Abcdefghijklmnopqrstuvwxyz", valid: true},
+		{name: "Default Accent", policy: namePolicy{}, input: "Zoë", valid: false},
+		{name: "Default Underscore", policy: namePolicy{}, input: "a_b", valid: false},
+		{name: "Default Empty", policy: namePolicy{}, input: "", valid: false},
+		{name: "Accent", policy: custom, input: "Zoë", valid: true},
+		{name: "Cyrillic", policy: custom, input: "Борис", valid: true},
+		{name: "Extra Characters", policy: custom, input: "a_b-c", valid: true},
+		{name: "Too Long", policy: custom, input: "Борисс", valid: false},
+		{name: "Combining Mark", policy: custom, input: "Zoe\u0308", valid: false},
+		{name: "Space", policy: custom, input: "a b", valid: false},
+		{name: "Invalid UTF-8", policy: custom, input: "a\xffb", valid: false},
+		{name: "Reserved", policy: custom, input: "Admin", valid: true, reserved: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tc.policy.valid(tc.input), tc.valid)
+			is.Equal(tc.policy.isReserved(tc.input), tc.reserved)
+		})
+	}
+}
+
+func TestNamePolicyNormalize(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(namePolicy{}.normalize("Ａlice"), "Ａlice") // untouched when only ASCII is allowed
+	is.Equal(namePolicy{unicode: true}.normalize("Ａｌｉｃｅ１"), "Alice1")
+	is.Equal(namePolicy{unicode: true}.normalize("Zoë"), "Zoë")
+	is.Equal(namePolicy{unicode: true}.normalize("Zoe\u0308"), "Zoë") // composed
+	is.Equal(namePolicy{unicode: true}.normalize("\ufb01le"), "file") // ligature split
+
+	is.True(namePolicy{}.same("Bob", "Bob"))
+	is.True(!namePolicy{}.same("Bob", "bob"))
+	is.True(namePolicy{foldCase: true}.same("Bob", "bob"))
+	is.True(namePolicy{foldCase: true}.same("STRASSE", "straße"))
+	is.True(namePolicy{reserved: []string{"root"}}.isReserved("ROOT"))
+}
+
+func TestNamePolicyServer(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.namePolicy = namePolicy{unicode: true, foldCase: true, reserved: []string{"admin"}}
+	s.hub.nicks.foldCase = true
+
+	bobClientConn, bobServerConn := net.Pipe()
+	zoeClientConn, zoeServerConn := net.Pipe()
+	defer bobClientConn.Close()
+	defer zoeClientConn.Close()
+
+	serveTestConns(s, bobServerConn, zoeServerConn)
+
+	bob := bufio.NewScanner(bobClientConn)
+	zoe := bufio.NewScanner(zoeClientConn)
+
+	join(m, bobClientConn, bob, "Bob", "")
+
+	// names differing only in case are taken, and reserved names refused
+	for _, name := range []string{"bob", "ADMIN"} {
+		dupeClientConn, dupeServerConn := net.Pipe()
+		serveTestConns(s, dupeServerConn)
+
+		dupe := bufio.NewScanner(dupeClientConn)
+		m.assert(dupe, "Welcome to budgetchat! What shall I call you?")
+		dupeClientConn.Write([]byte(name + "\n"))
+		m.assert(dupe, "invalid name: "+name)
+		dupeClientConn.Close()
+	}
+
+	// fullwidth letters are folded to ASCII
+	join(m, zoeClientConn, zoe, "Ｚoë", "Bob")
+	m.assert(bob, "* Zoë has entered the room")
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/nick BOB", expected: "* Name taken: BOB"},
+		{input: "/nick Admin", expected: "* Name reserved: Admin"},
+		{input: "/nick zoë", expected: "* Zoë is now known as zoë"},
+	}
+
+	for _, tc := range tt {
+		zoeClientConn.Write([]byte(tc.input + "\n"))
+		m.assert(zoe, tc.expected)
+	}
+	m.assert(bob, "* Zoë is now known as zoë")
+
+	// private messages find names in any case
+	bobClientConn.Write([]byte("/msg ZOË Hi\n"))
+	m.assert(bob, "[Bob -> zoë] Hi")
+	m.assert(zoe, "[Bob -> zoë] Hi")
+}
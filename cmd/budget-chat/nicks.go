@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errAlreadyRegistered = errors.New("already registered")
+
+// maxPasswordLength is the most of a password bcrypt uses.
+const maxPasswordLength = 72
+
+// nickStore holds the registered names. Checking a password is slow by design,
+// so is done off the hub goroutine, and the store has a lock of its own.
+//
+// Registrations are saved to a file of lines like "Alice <bcrypt hash>".
+type nickStore struct {
+	path     string // empty when registrations are not saved
+	nicks    map[string][]byte
+	foldCase bool // a registration covers the name in any case
+	cost     int  // of bcrypt, lowered in tests
+	sync.Mutex
+}
+
+func newNickStore() *nickStore {
+	return &nickStore{nicks: make(map[string][]byte), cost: bcrypt.DefaultCost}
+}
+
+// loadNickStore reads the registrations saved at path, which need not exist
+// yet.
+func loadNickStore(path string) (*nickStore, error) {
+	s := newNickStore()
+	s.path = path
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load nicks: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("load nicks: line %d: expected name and hash", line)
+		}
+
+		hash := []byte(fields[1])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("load nicks: line %d: hash: %w", line, err)
+		}
+
+		s.nicks[fields[0]] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("load nicks: %w", err)
+	}
+
+	return s, nil
+}
+
+// lookup finds the hash of the registration covering a name. The caller
+// holds the lock.
+func (s *nickStore) lookup(name string) ([]byte, bool) {
+	if hash, ok := s.nicks[name]; ok || !s.foldCase {
+		return hash, ok
+	}
+	for registered, hash := range s.nicks {
+		if equalFold(registered, name) {
+			return hash, true
+		}
+	}
+	return nil, false
+}
+
+func (s *nickStore) registered(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.lookup(name)
+	return ok
+}
+
+// register protects a name with a password.
+func (s *nickStore) register(name string, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return fmt.Errorf("register %s: %w", name, err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.lookup(name); ok {
+		return errAlreadyRegistered
+	}
+
+	s.nicks[name] = hash
+
+	if err := s.save(); err != nil {
+		delete(s.nicks, name)
+		return err
+	}
+	return nil
+}
+
+// check reports whether the password is the one the name was registered with.
+func (s *nickStore) check(name string, password string) bool {
+	s.Lock()
+	hash, ok := s.lookup(name)
+	s.Unlock()
+
+	return ok && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// save writes the registrations. The caller holds the lock.
+func (s *nickStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var lines []string
+	for name, hash := range s.nicks {
+		lines = append(lines, fmt.Sprintf("%s %s\n", name, hash))
+	}
+	sort.Strings(lines)
+
+	if err := writeFileAtomic(s.path, []byte(strings.Join(lines, ""))); err != nil {
+		return fmt.Errorf("save nicks: %w", err)
+	}
+
+	return nil
+}
+
+// changeNick renames a client, e.g. "/nick Bob". A registered name is only
+// taken once the client identifies as its owner.
+func changeNick(h *hub, c *client, arg string) {
+	arg = h.namePolicy.normalize(arg)
+
+	// the client may change the case of its own name
+	switch other, taken := h.named(arg); {
+	case arg == "":
+		c.send("* Usage: /nick <name>\n")
+	case arg == c.name:
+		c.send(fmt.Sprintf("* You are already known as %s\n", arg))
+	case !h.namePolicy.valid(arg):
+		c.send(fmt.Sprintf("* Invalid name: %s\n", arg))
+	case h.namePolicy.isReserved(arg):
+		c.send(fmt.Sprintf("* Name reserved: %s\n", arg))
+	case taken && other != c:
+		c.send(fmt.Sprintf("* Name taken: %s\n", arg))
+	case h.nicks.registered(arg) && !h.namePolicy.same(c.identified, arg):
+		c.wantedName = arg
+		c.send(fmt.Sprintf("* %s is registered, /identify <password> to take it\n", arg))
+	default:
+		h.rename(c, arg)
+	}
+}
+
+// registerNick protects the client's name with a password.
+func registerNick(h *hub, c *client, arg string) {
+	switch {
+	case arg == "":
+		c.send("* Usage: /register <password>\n")
+		return
+	case len(arg) > maxPasswordLength:
+		c.send(fmt.Sprintf("* Passwords may be at most %d bytes\n", maxPasswordLength))
+		return
+	case h.nicks.registered(c.name):
+		c.send(fmt.Sprintf("* %s is already registered\n", c.name))
+		return
+	}
+
+	name := c.name
+	go func() {
+		err := h.nicks.register(name, arg)
+		if err != nil && !errors.Is(err, errAlreadyRegistered) {
+			log.Println(err)
+		}
+		h.passwordChecks <- passwordCheck{client: c, name: name, register: true, err: err}
+	}()
+}
+
+// identifyNick proves that the client owns its registered name, or the one it
+// asked for with /nick.
+func identifyNick(h *hub, c *client, arg string) {
+	name := c.name
+	if c.wantedName != "" {
+		name = c.wantedName
+	}
+
+	if arg == "" {
+		c.send("* Usage: /identify <password>\n")
+		return
+	}
+
+	if !h.nicks.registered(name) {
+		c.send(fmt.Sprintf("* %s is not registered\n", name))
+		return
+	}
+
+	go func() {
+		var err error
+		if !h.nicks.check(name, arg) {
+			err = errIncorrectPassword
+		}
+		h.passwordChecks <- passwordCheck{client: c, name: name, err: err}
+	}()
+}
+
+// passwordCheck is the outcome of registering or identifying, which hash
+// passwords off the hub goroutine.
+type passwordCheck struct {
+	client   *client
+	name     string
+	register bool
+	err      error
+}
+
+// handlePasswordCheck tells the client how its /register or /identify went,
+// giving it the name it identified as if it is still free.
+func (h *hub) handlePasswordCheck(p passwordCheck) {
+	c := p.client
+	if c.room == nil || !h.connected(c) {
+		return
+	}
+
+	switch {
+	case errors.Is(p.err, errAlreadyRegistered):
+		c.send(fmt.Sprintf("* %s is already registered\n", p.name))
+		return
+	case errors.Is(p.err, errIncorrectPassword):
+		log.Printf("failed /identify as %s from %s\n", p.name, c.addr)
+		c.send("* Incorrect password\n")
+		return
+	case p.err != nil:
+		c.send(fmt.Sprintf("* Could not register %s\n", p.name))
+		return
+	}
+
+	c.identified = p.name
+	if p.register {
+		c.send(fmt.Sprintf("* Registered %s\n", p.name))
+		return
+	}
+	c.send(fmt.Sprintf("* You are now identified as %s\n", p.name))
+
+	if c.wantedName == p.name {
+		c.wantedName = ""
+		if other, taken := h.named(p.name); taken && other != c {
+			c.send(fmt.Sprintf("* Name taken: %s\n", p.name))
+		} else if c.name != p.name {
+			h.rename(c, p.name)
+		}
+	}
+}
+
+// rename changes a client's name, telling its room.
+func (h *hub) rename(c *client, name string) {
+	e := nickEvent{from: c.name, to: name, room: c.room.name}
+	c.name = name
+	c.wantedName = ""
+
+	h.record(e)
+	h.relay(e)
+	c.room.broadcast(c, e)
+	c.sendEvent(e)
+}
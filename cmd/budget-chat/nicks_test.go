@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNickChanges(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	startTestServer(aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/nick", expected: "* Usage: /nick <name>"},
+		{input: "/nick Bob", expected: "* You are already known as Bob"},
+		{input: "/nick Alice", expected: "* Name taken: Alice"},
+		{input: "/nick b@d", expected: "* Invalid name: b@d"},
+		{input: "/nick Robert", expected: "* Bob is now known as Robert"},
+	}
+
+	for _, tc := range tt {
+		bobClientConn.Write([]byte(tc.input + "\n"))
+		m.assert(bob, tc.expected)
+	}
+	m.assert(alice, "* Bob is now known as Robert")
+
+	// the new name is used everywhere
+	aliceClientConn.Write([]byte("/msg Robert Nice name\n"))
+	m.assert(alice, "[Alice -> Robert] Nice name")
+	m.assert(bob, "[Alice -> Robert] Nice name")
+
+	bobClientConn.Write([]byte("Thanks\n"))
+	m.assert(alice, "[Robert] Thanks")
+}
+
+func TestNickRegistration(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	path := filepath.Join(t.TempDir(), "nicks")
+	nicks, err := loadNickStore(path)
+	m.is.NoErr(err)
+	nicks.cost = bcrypt.MinCost
+
+	s := NewChatServer(5000)
+	s.hub.nicks = nicks
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/identify secret", expected: "* Alice is not registered"},
+		{input: "/register", expected: "* Usage: /register <password>"},
+		{input: "/register " + strings.Repeat("x", 73), expected: "* Passwords may be at most 72 bytes"},
+		{input: "/register secret", expected: "* Registered Alice"},
+		{input: "/register other", expected: "* Alice is already registered"},
+		{input: "/nick Alicia", expected: "* Alice is now known as Alicia"},
+	}
+
+	for _, tc := range tt {
+		aliceClientConn.Write([]byte(tc.input + "\n"))
+		m.assert(alice, tc.expected)
+	}
+	m.assert(bob, "* Alice is now known as Alicia")
+
+	// others may not take a registered name without the password
+	bobClientConn.Write([]byte("/nick Alice\n"))
+	m.assert(bob, "* Alice is registered, /identify <password> to take it")
+	bobClientConn.Write([]byte("/identify guess\n"))
+	m.assert(bob, "* Incorrect password")
+	bobClientConn.Write([]byte("/names\n"))
+	m.assert(bob, "* The room contains: Alicia, Bob")
+
+	// nor join under it
+	carolClientConn, carolServerConn := net.Pipe()
+	defer carolClientConn.Close()
+	serveTestConns(s, carolServerConn)
+
+	carol := bufio.NewScanner(carolClientConn)
+	m.assert(carol, "Welcome to budgetchat! What shall I call you?")
+	carolClientConn.Write([]byte("Alice\n"))
+	m.assert(carol, "* Alice is registered, /identify <password> to use it or give another name")
+	carolClientConn.Write([]byte("/identify guess\n"))
+	m.assert(carol, "* Incorrect password")
+
+	// the password lets anyone use the name
+	carolClientConn.Write([]byte("/identify secret\n"))
+	m.assert(carol, "* The room contains: Alicia, Bob")
+	m.assert(alice, "* Alice has entered the room")
+	m.assert(bob, "* Alice has entered the room")
+	carolClientConn.Close()
+	m.assert(alice, "* Alice has left the room")
+	m.assert(bob, "* Alice has left the room")
+
+	bobClientConn.Write([]byte("/nick Alice\n"))
+	m.assert(bob, "* Alice is registered, /identify <password> to take it")
+	bobClientConn.Write([]byte("/identify secret\n"))
+	m.assert(bob, "* You are now identified as Alice")
+	m.assert(bob, "* Bob is now known as Alice")
+	m.assert(alice, "* Bob is now known as Alice")
+
+	// registrations are kept across restarts
+	restarted, err := loadNickStore(path)
+	m.is.NoErr(err)
+	m.is.True(restarted.check("Alice", "secret"))
+	m.is.True(!restarted.check("Alice", "guess"))
+	m.is.True(!restarted.check("Bob", "secret"))
+
+	saved, err := os.ReadFile(path)
+	m.is.NoErr(err)
+	m.is.True(!strings.Contains(string(saved), "secret")) // only hashes are saved
+
+	m.is.NoErr(os.WriteFile(path, []byte("Alice nothash\n"), 0o600))
+	_, err = loadNickStore(path)
+	m.is.True(err != nil) // malformed hash
+}
+
+func TestNickRegistrationIRC(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.nicks.cost = bcrypt.MinCost
+	m.is.NoErr(s.hub.nicks.register("Alice", "secret"))
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	serveIRCConns(s, aliceServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+
+	tt := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "NICK Alice"},
+		{input: "USER alice 0 * :Alice", expected: []string{":budgetchat 433 * Alice :Nickname is registered, send PASS first"}},
+		{input: "PASS guess"},
+		{input: "NICK Alice", expected: []string{":budgetchat 464 * :Password incorrect"}},
+		{input: "PASS secret"},
+		{input: "NICK Alice", expected: []string{
+			":budgetchat 001 Alice :Welcome to budgetchat, Alice",
+			":budgetchat 422 Alice :MOTD File is missing",
+			":Alice!Alice@budgetchat JOIN #general",
+			":budgetchat 353 Alice = #general :Alice",
+			":budgetchat 366 Alice #general :End of /NAMES list",
+		}},
+		{input: "PRIVMSG NickServ :REGISTER other", expected: []string{":budgetchat NOTICE Alice :* Alice is already registered"}},
+		{input: "PRIVMSG NickServ :IDENTIFY secret", expected: []string{":budgetchat NOTICE Alice :* You are now identified as Alice"}},
+		{input: "PRIVMSG NickServ :HELP", expected: []string{":budgetchat NOTICE Alice :* NickServ knows REGISTER <password> and IDENTIFY <password>"}},
+	}
+
+	for _, tc := range tt {
+		aliceClientConn.Write([]byte(tc.input + "\r\n"))
+		for _, want := range tc.expected {
+			m.assert(alice, want)
+		}
+	}
+}
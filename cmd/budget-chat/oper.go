@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// operOnly wraps a command that only operators may use.
+func operOnly(run commandHandler) commandHandler {
+	return func(h *hub, c *client, arg string) {
+		if !c.oper {
+			c.send("* Only operators may do that\n")
+			return
+		}
+		run(h, c, arg)
+	}
+}
+
+// becomeOper makes a client an operator if it gives the configured password.
+func becomeOper(h *hub, c *client, arg string) {
+	if h.operPassword == "" {
+		c.send("* Operators are disabled\n")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(arg), []byte(h.operPassword)) != 1 {
+		log.Printf("failed /oper from %s\n", c.addr)
+		c.send("* Incorrect password\n")
+		return
+	}
+
+	c.oper = true
+	c.send("* You are now an operator\n")
+}
+
+// kickUser disconnects a user, who may come straight back.
+func kickUser(h *hub, c *client, arg string) {
+	target, ok := h.named(arg)
+	if !ok {
+		c.send(fmt.Sprintf("* No such user: %s\n", arg))
+		return
+	}
+
+	disconnect(target, fmt.Sprintf("* You have been kicked by %s\n", c.name))
+	c.send(fmt.Sprintf("* Kicked %s\n", target.name))
+}
+
+// banUser bans the address of a user, or an address given as is, for a
+// duration or for good, disconnecting everyone connected from it.
+func banUser(h *hub, c *client, arg string) {
+	who, duration, _ := cutArg(arg)
+	if who == "" {
+		c.send("* Usage: /ban <name|ip> [duration]\n")
+		return
+	}
+
+	ip := who
+	if target, ok := h.named(who); ok {
+		ip = target.ip
+	} else if net.ParseIP(who) == nil {
+		c.send(fmt.Sprintf("* No such user or IP: %s\n", who))
+		return
+	}
+
+	now := h.now()
+
+	var until time.Time
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			c.send(fmt.Sprintf("* Invalid duration: %s\n", duration))
+			return
+		}
+		until = now.Add(d)
+	}
+
+	if err := h.bans.add(ip, until, now); err != nil {
+		// the ban holds until the server restarts
+		log.Println(err)
+	}
+
+	for _, other := range h.clients {
+		if other.ip == ip && other != c {
+			disconnect(other, fmt.Sprintf("* You have been banned by %s\n", c.name))
+		}
+	}
+
+	if until.IsZero() {
+		c.send(fmt.Sprintf("* Banned %s permanently\n", who))
+	} else {
+		c.send(fmt.Sprintf("* Banned %s for %s\n", who, duration))
+	}
+}
+
+// muteUser stops a user talking until unmuted.
+func muteUser(h *hub, c *client, arg string) {
+	target, ok := h.named(arg)
+	if !ok {
+		c.send(fmt.Sprintf("* No such user: %s\n", arg))
+		return
+	}
+
+	target.muted = true
+	target.send(fmt.Sprintf("* You have been muted by %s\n", c.name))
+	c.send(fmt.Sprintf("* Muted %s\n", target.name))
+}
+
+// unmuteUser lifts an operator's mute and any mute for flooding.
+func unmuteUser(h *hub, c *client, arg string) {
+	target, ok := h.named(arg)
+	if !ok {
+		c.send(fmt.Sprintf("* No such user: %s\n", arg))
+		return
+	}
+
+	target.muted = false
+	target.flood.mutedUntil = time.Time{}
+	target.send(fmt.Sprintf("* You have been unmuted by %s\n", c.name))
+	c.send(fmt.Sprintf("* Unmuted %s\n", target.name))
+}
+
+// disconnect tells a client why it is being disconnected. The connection
+// closes once the notice is written, and the client leaves as usual, with no
+// chance to resume.
+func disconnect(c *client, notice string) {
+	c.resumeToken = ""
+	c.send(notice)
+	c.close()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// addrConn gives a pipe a remote address, as bans need one.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// pipeFrom returns a pipe whose server end appears to come from ip.
+func pipeFrom(ip string) (net.Conn, net.Conn) {
+	clientConn, serverConn := net.Pipe()
+	return clientConn, addrConn{Conn: serverConn, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+func TestOperators(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+
+	s := NewChatServer(5000)
+	s.hub.now = clock.Now
+	s.hub.operPassword = "hunter2"
+
+	aliceClientConn, aliceServerConn := pipeFrom("10.0.0.1")
+	bobClientConn, bobServerConn := pipeFrom("10.0.0.2")
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	aliceClientConn.Write([]byte("/kick Bob\n"))
+	m.assert(alice, "* Only operators may do that")
+
+	aliceClientConn.Write([]byte("/oper letmein\n"))
+	m.assert(alice, "* Incorrect password")
+
+	aliceClientConn.Write([]byte("/oper hunter2\n"))
+	m.assert(alice, "* You are now an operator")
+
+	// muted users cannot talk to others
+	aliceClientConn.Write([]byte("/mute Bob\n"))
+	m.assert(bob, "* You have been muted by Alice")
+	m.assert(alice, "* Muted Bob")
+
+	for _, line := range []string{"Let me speak", "/me protests", "/msg Alice Please"} {
+		bobClientConn.Write([]byte(line + "\n"))
+		m.assert(bob, "* You are muted")
+	}
+
+	aliceClientConn.Write([]byte("/unmute Bob\n"))
+	m.assert(bob, "* You have been unmuted by Alice")
+	m.assert(alice, "* Unmuted Bob")
+
+	bobClientConn.Write([]byte("Thanks\n"))
+	m.assert(alice, "[Bob] Thanks")
+
+	// a kicked user may come back
+	aliceClientConn.Write([]byte("/kick Bob\n"))
+	m.assert(bob, "* You have been kicked by Alice")
+	m.is.True(!bob.Scan())
+	m.assert(alice, "* Kicked Bob")
+	m.assert(alice, "* Bob has left the room")
+
+	bobClientConn, bobServerConn = pipeFrom("10.0.0.2")
+	defer bobClientConn.Close()
+	serveTestConns(s, bobServerConn)
+	bob = bufio.NewScanner(bobClientConn)
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	// a banned user may not, until the ban expires
+	aliceClientConn.Write([]byte("/ban Bob 1h\n"))
+	m.assert(bob, "* You have been banned by Alice")
+	m.is.True(!bob.Scan())
+	m.assert(alice, "* Banned Bob for 1h")
+	m.assert(alice, "* Bob has left the room")
+
+	bobClientConn, bobServerConn = pipeFrom("10.0.0.2")
+	defer bobClientConn.Close()
+	serveTestConns(s, bobServerConn)
+	bob = bufio.NewScanner(bobClientConn)
+	m.assert(bob, "* You are banned")
+	m.is.True(!bob.Scan())
+
+	clock.Advance(time.Hour)
+
+	bobClientConn, bobServerConn = pipeFrom("10.0.0.2")
+	defer bobClientConn.Close()
+	serveTestConns(s, bobServerConn)
+	bob = bufio.NewScanner(bobClientConn)
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/ban", expected: "* Usage: /ban <name|ip> [duration]"},
+		{input: "/ban Dave", expected: "* No such user or IP: Dave"},
+		{input: "/ban Bob forever", expected: "* Invalid duration: forever"},
+		{input: "/kick Dave", expected: "* No such user: Dave"},
+		{input: "/mute Dave", expected: "* No such user: Dave"},
+		{input: "/ban 10.0.0.9", expected: "* Banned 10.0.0.9 permanently"},
+	}
+
+	for _, tc := range tt {
+		aliceClientConn.Write([]byte(tc.input + "\n"))
+		m.assert(alice, tc.expected)
+	}
+}
+
+func TestOperatorsDisabled(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	startTestServer(serverConn)
+
+	clientScanner := bufio.NewScanner(clientConn)
+	join(m, clientConn, clientScanner, "Alice", "")
+
+	// an empty password would otherwise let anyone in
+	clientConn.Write([]byte("/oper\n"))
+	m.assert(clientScanner, "* Operators are disabled")
+}
+
+func TestBansPersist(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "bans")
+
+	bans, err := loadBanList(path)
+	is.NoErr(err) // a missing file has no bans
+
+	is.NoErr(bans.add("10.0.0.1", time.Time{}, now))
+	is.NoErr(bans.add("10.0.0.2", now.Add(time.Hour), now))
+	is.NoErr(bans.add("10.0.0.3", now.Add(time.Minute), now))
+
+	saved, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.Equal(string(saved), "10.0.0.1 permanent\n10.0.0.2 2022-12-01T13:00:00Z\n10.0.0.3 2022-12-01T12:01:00Z\n")
+
+	// expired bans are dropped when next saved
+	later := now.Add(30 * time.Minute)
+	is.NoErr(bans.add("10.0.0.4", time.Time{}, later))
+
+	restarted, err := loadBanList(path)
+	is.NoErr(err)
+	is.True(restarted.banned("10.0.0.1", later))
+	is.True(restarted.banned("10.0.0.2", later))
+	is.True(!restarted.banned("10.0.0.3", later))
+	is.True(restarted.banned("10.0.0.4", later))
+	is.True(!restarted.banned("10.0.0.2", now.Add(2*time.Hour)))
+	is.True(!restarted.banned("10.0.0.5", later))
+
+	is.NoErr(os.WriteFile(path, []byte("10.0.0.1 tomorrow\n"), 0o644))
+	_, err = loadBanList(path)
+	is.True(err != nil) // malformed expiry
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A client that drops is kept in its room for a grace period, in which a new
+// connection may carry on as it by giving its resume token in place of a
+// name. Nobody is told it left and came back.
+
+// resume has a new connection carry on as a client that dropped, reporting
+// false if the token is unknown.
+func (h *hub) resume(c *client, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	joined := make(chan bool)
+	h.joins <- joinRequest{client: c, token: token, joined: joined}
+	return <-joined
+}
+
+// issueResumeToken gives a client a new token to resume with. IRC clients
+// have no way to use one.
+func (h *hub) issueResumeToken(c *client) {
+	if h.resumeGrace == 0 {
+		return
+	}
+	if _, ok := c.proto.(*ircProtocol); ok {
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("resume token for %s: %s\n", c.name, err)
+		return
+	}
+
+	c.resumeToken = hex.EncodeToString(b)
+	c.send(fmt.Sprintf("* Resume token: %s\n", c.resumeToken))
+}
+
+// detach keeps a client that has dropped in its room for the grace period,
+// reporting false if it cannot be resumed. Clients disconnected by the server
+// give up their tokens.
+func (h *hub) detach(c *client) bool {
+	if c.resumeToken == "" || c.room == nil || c.detached {
+		return false
+	}
+
+	c.detached = true
+	h.stopWatchingIdle(c)
+	time.AfterFunc(h.resumeGrace, func() {
+		h.graceExpiries <- c
+	})
+
+	log.Printf("connection from %s dropped, %s may resume within %s\n", c.addr, c.name, h.resumeGrace)
+	return true
+}
+
+// expireGrace removes a client that dropped and was not resumed in time.
+func (h *hub) expireGrace(c *client) {
+	if c.detached && h.connected(c) {
+		c.resumeToken = ""
+		h.handleLeave(c)
+	}
+}
+
+// handleResume has a new connection take the place of the dropped client
+// with the token, keeping its name, room and standing.
+func (h *hub) handleResume(c *client, token string) bool {
+	var old *client
+	for _, other := range h.clients {
+		if other.detached && subtle.ConstantTimeCompare([]byte(other.resumeToken), []byte(token)) == 1 {
+			old = other
+			break
+		}
+	}
+	if old == nil {
+		return false
+	}
+
+	c.name, c.room = old.name, old.room
+	c.connected, c.flood = old.connected, old.flood
+	c.oper, c.muted, c.identified = old.oper, old.muted, old.identified
+
+	var others []string
+	for i, m := range c.room.members {
+		if m == old {
+			c.room.members[i] = c
+		} else {
+			others = append(others, m.name)
+		}
+	}
+	for _, u := range c.room.remotes {
+		others = append(others, u.name)
+	}
+
+	// the old client is gone for good, and any deadline it had is void
+	h.forget(old)
+	old.name, old.room = "", nil
+
+	log.Printf("connection from %s resumed %s\n", c.addr, c.name)
+
+	c.send(fmt.Sprintf("* Resumed as %s\n", c.name))
+	c.sendEvent(namesEvent{room: c.room.name, names: others})
+	h.issueResumeToken(c)
+	h.watchIdle(c)
+
+	return true
+}
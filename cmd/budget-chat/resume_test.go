@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readToken reads the resume token a client is given.
+func readToken(m *messageExpecter, scn *bufio.Scanner) string {
+	scn.Scan()
+	token := strings.TrimPrefix(scn.Text(), "* Resume token: ")
+	m.is.True(token != scn.Text()) // a token
+	return token
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.resumeGrace = time.Minute
+
+	aliceClientConn, alice := connectTo(s)
+	bobClientConn, bob := connectTo(s)
+	defer bobClientConn.Close()
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	token := readToken(m, alice)
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	readToken(m, bob)
+	m.assert(alice, "* Bob has entered the room")
+
+	// Alice drops, and her name is kept for her
+	aliceClientConn.Close()
+
+	aliceClientConn, alice = connectTo(s)
+	defer aliceClientConn.Close()
+	m.assert(alice, "Welcome to budgetchat! What shall I call you?")
+	aliceClientConn.Write([]byte("Alice\n"))
+	m.assert(alice, "invalid name: Alice")
+
+	aliceClientConn, alice = connectTo(s)
+	defer aliceClientConn.Close()
+	m.assert(alice, "Welcome to budgetchat! What shall I call you?")
+	aliceClientConn.Write([]byte("/resume nonsense\n"))
+	m.assert(alice, "* Unknown resume token")
+	aliceClientConn.Write([]byte("/resume " + token + "\n"))
+	m.assert(alice, "* Resumed as Alice")
+	m.assert(alice, "* The room contains: Bob")
+	m.is.True(readToken(m, alice) != token) // a new token each time
+
+	// Bob is told nothing of Alice leaving and coming back
+	bobClientConn.Write([]byte("Welcome back\n"))
+	m.assert(alice, "[Bob] Welcome back")
+	aliceClientConn.Write([]byte("Thanks\n"))
+	m.assert(bob, "[Alice] Thanks")
+
+	// a token is only good once
+	carolClientConn, carol := connectTo(s)
+	defer carolClientConn.Close()
+	m.assert(carol, "Welcome to budgetchat! What shall I call you?")
+	carolClientConn.Write([]byte("/resume " + token + "\n"))
+	m.assert(carol, "* Unknown resume token")
+	carolClientConn.Write([]byte("Carol\n"))
+	m.assert(carol, "* The room contains: Alice, Bob") // Alice kept her place
+}
+
+func TestResumeExpires(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	s.hub.resumeGrace = 50 * time.Millisecond
+	s.hub.operPassword = "hunter2"
+
+	aliceClientConn, alice := connectTo(s)
+	bobClientConn, bob := connectTo(s)
+	defer bobClientConn.Close()
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	token := readToken(m, alice)
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	readToken(m, bob)
+	m.assert(alice, "* Bob has entered the room")
+
+	aliceClientConn.Close()
+	m.assert(bob, "* Alice has left the room")
+
+	aliceClientConn, alice = connectTo(s)
+	defer aliceClientConn.Close()
+	m.assert(alice, "Welcome to budgetchat! What shall I call you?")
+	aliceClientConn.Write([]byte("/resume " + token + "\n"))
+	m.assert(alice, "* Unknown resume token")
+
+	// nor may kicked clients
+	carolClientConn, carol := connectTo(s)
+	defer carolClientConn.Close()
+	join(m, carolClientConn, carol, "Carol", "Bob")
+	token = readToken(m, carol)
+	m.assert(bob, "* Carol has entered the room")
+
+	bobClientConn.Write([]byte("/oper hunter2\n"))
+	m.assert(bob, "* You are now an operator")
+	bobClientConn.Write([]byte("/kick Carol\n"))
+	m.assert(carol, "* You have been kicked by Bob")
+	m.assert(bob, "* Kicked Carol")
+	m.assert(bob, "* Carol has left the room")
+
+	aliceClientConn.Write([]byte("/resume " + token + "\n"))
+	m.assert(alice, "* Unknown resume token")
+}
+
+func TestResumeDisabled(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	// with the default configuration, "/resume" is just an invalid name
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	startTestServer(serverConn)
+
+	client := bufio.NewScanner(clientConn)
+	m.assert(client, "Welcome to budgetchat! What shall I call you?")
+	clientConn.Write([]byte("/resume abc\n"))
+	m.assert(client, "invalid name: /resume abc")
+	m.is.True(!client.Scan()) // connection closed
+}
@@ -0,0 +1,66 @@
+// Command search prints the entries of a budget-chat transcript, including
+// its rotated files, that match all of the given filters.
+//
+//	go run ./cmd/budget-chat/search -transcript transcript.log -user Alice -contains hello
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+func main() {
+	path := flag.String("transcript", "transcript.log", "Transcript to search, as passed to budget-chat")
+	user := flag.String("user", "", "Only entries by this user")
+	room := flag.String("room", "", "Only entries in this room")
+	since := flag.String("since", "", "Only entries at or after this RFC 3339 time, e.g. 2022-12-01T12:00:00Z")
+	until := flag.String("until", "", "Only entries at or before this RFC 3339 time")
+	contains := flag.String("contains", "", "Only messages containing this text")
+	raw := flag.Bool("raw", false, "Print entries as they are logged")
+	flag.Parse()
+
+	f := transcript.Filter{User: *user, Room: *room, Contains: *contains}
+
+	var err error
+	if f.Since, err = parseTime(*since); err != nil {
+		log.Fatalf("-since: %s", err)
+	}
+	if f.Until, err = parseTime(*until); err != nil {
+		log.Fatalf("-until: %s", err)
+	}
+
+	if err := search(os.Stdout, *path, f, *raw); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func search(w io.Writer, path string, f transcript.Filter, raw bool) error {
+	var werr error
+	err := transcript.Each(path, func(e transcript.Entry) {
+		if werr != nil || !f.Match(e) {
+			return
+		}
+		if raw {
+			_, werr = fmt.Fprintln(w, e)
+		} else {
+			_, werr = fmt.Fprintln(w, e.Describe())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.log")
+
+	log := strings.Join([]string{
+		"2022-12-01T12:00:00Z\tjoin\tgeneral\tAlice\t",
+		"2022-12-01T12:01:00Z\tmessage\tgeneral\tAlice\tHello, world",
+		"2022-12-01T12:02:00Z\tjoin\tgeneral\tBob\t",
+		"2022-12-01T12:03:00Z\tmessage\tgeneral\tBob\tHello, Alice",
+		"2022-12-01T12:04:00Z\temote\tdev\tAlice\twaves",
+		"",
+	}, "\n")
+	is.New(t).NoErr(os.WriteFile(path, []byte(log), 0o644))
+
+	at := func(minutes int) time.Time {
+		return time.Date(2022, 12, 1, 12, minutes, 0, 0, time.UTC)
+	}
+
+	tt := []struct {
+		name   string
+		filter transcript.Filter
+		raw    bool
+		want   string
+	}{
+		{
+			name:   "Substring",
+			filter: transcript.Filter{Contains: "Hello"},
+			want:   "2022-12-01 12:01:00 general [Alice] Hello, world\n2022-12-01 12:03:00 general [Bob] Hello, Alice\n",
+		},
+		{
+			name:   "User And Time",
+			filter: transcript.Filter{User: "Alice", Since: at(1), Until: at(4)},
+			want:   "2022-12-01 12:01:00 general [Alice] Hello, world\n2022-12-01 12:04:00 dev * Alice waves\n",
+		},
+		{
+			name:   "Presence",
+			filter: transcript.Filter{User: "Bob", Until: at(2)},
+			want:   "2022-12-01 12:02:00 general * Bob has entered the room\n",
+		},
+		{
+			name:   "Raw",
+			filter: transcript.Filter{Room: "dev"},
+			raw:    true,
+			want:   "2022-12-01T12:04:00Z\temote\tdev\tAlice\twaves\n",
+		},
+		{
+			name:   "No Matches",
+			filter: transcript.Filter{User: "Charlie"},
+			want:   "",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var out bytes.Buffer
+			is.NoErr(search(&out, path, tc.filter, tc.raw))
+			is.Equal(out.String(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+// record appends an event to the transcript. Private messages are never
+// recorded.
+func (h *hub) record(e event) {
+	if h.transcript == nil {
+		return
+	}
+
+	entry := transcript.Entry{Time: h.now()}
+	switch e := e.(type) {
+	case chatEvent:
+		entry.Kind, entry.Room, entry.User, entry.Text = transcript.Message, e.room, e.from, e.text
+	case emoteEvent:
+		entry.Kind, entry.Room, entry.User, entry.Text = transcript.Emote, e.room, e.from, e.action
+	case enterEvent:
+		entry.Kind, entry.Room, entry.User = transcript.Join, e.room, e.name
+	case leaveEvent:
+		entry.Kind, entry.Room, entry.User = transcript.Leave, e.room, e.name
+	case nickEvent:
+		entry.Kind, entry.Room, entry.User, entry.Text = transcript.Nick, e.room, e.from, e.to
+	default:
+		return
+	}
+
+	if err := h.transcript.Append(entry); err != nil {
+		log.Printf("transcript: %s\n", err)
+	}
+}
+
+// restoreHistory fills the history of each room from the transcript, so that
+// what was said survives the server restarting. The transcript is read once,
+// before clients connect, from the newest file back, and reading stops at the
+// first line older than -history-age or once every room found so far holds
+// -history-lines lines. Rooms last spoken in before that start empty.
+func (h *hub) restoreHistory() {
+	if h.transcript == nil || !h.history.enabled() {
+		return
+	}
+
+	var oldest time.Time
+	if h.history.age > 0 {
+		oldest = h.now().Add(-h.history.age)
+	}
+
+	restored := make(map[string][]transcript.Entry) // newest first
+	full := func() bool {
+		if h.history.lines == 0 || len(restored) == 0 {
+			return false
+		}
+		for _, entries := range restored {
+			if len(entries) < h.history.lines {
+				return false
+			}
+		}
+		return true
+	}
+
+	err := transcript.EachFileNewestFirst(h.transcript.Path(), func(entries []transcript.Entry) bool {
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if e.Kind != transcript.Message && e.Kind != transcript.Emote {
+				continue
+			}
+			if e.Time.Before(oldest) {
+				return false
+			}
+			if h.history.lines == 0 || len(restored[e.Room]) < h.history.lines {
+				restored[e.Room] = append(restored[e.Room], e)
+			}
+		}
+		// the whole file is read, so rooms spoken in just before another
+		// filled up are not missed
+		return !full()
+	})
+	if err != nil {
+		log.Printf("restore history: %s\n", err)
+	}
+
+	for room, entries := range restored {
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			switch e.Kind {
+			case transcript.Message:
+				h.roomHistory(room).add(e.Time, chatEvent{from: e.User, room: room, text: e.Text}.line())
+			case transcript.Emote:
+				h.roomHistory(room).add(e.Time, emoteEvent{from: e.User, room: room, action: e.Text}.line())
+			}
+		}
+	}
+	h.forgetHistories()
+}
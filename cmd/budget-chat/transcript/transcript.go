@@ -0,0 +1,305 @@
+// Package transcript keeps an append-only log of what is said in budget-chat
+// rooms, and who comes and goes.
+//
+// Each entry is a line of tab separated fields: the time in RFC 3339 format,
+// the kind of entry, the room, the user and any text. The text is last, so it
+// may itself contain tabs.
+//
+// The log rotates once it reaches a size or, if daily, on each new day in UTC.
+// Rotated files are renamed after the time they were started, e.g.
+// "transcript.log.20221201T120000", so that they sort in order.
+package transcript
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	Join    Kind = "join"
+	Leave   Kind = "leave"
+	Message Kind = "message"
+	Emote   Kind = "emote"
+	Nick    Kind = "nick" // the text is the new name
+)
+
+type Entry struct {
+	Time time.Time
+	Kind Kind
+	Room string
+	User string
+	Text string
+}
+
+// String formats the entry as a line of the log, without the newline.
+func (e Entry) String() string {
+	return strings.Join([]string{e.Time.UTC().Format(time.RFC3339), string(e.Kind), e.Room, e.User, e.Text}, "\t")
+}
+
+// Describe formats the entry for people to read.
+func (e Entry) Describe() string {
+	var what string
+	switch e.Kind {
+	case Join:
+		what = fmt.Sprintf("* %s has entered the room", e.User)
+	case Leave:
+		what = fmt.Sprintf("* %s has left the room", e.User)
+	case Emote:
+		what = fmt.Sprintf("* %s %s", e.User, e.Text)
+	case Nick:
+		what = fmt.Sprintf("* %s is now known as %s", e.User, e.Text)
+	default:
+		what = fmt.Sprintf("[%s] %s", e.User, e.Text)
+	}
+	return fmt.Sprintf("%s %s %s", e.Time.Format("2006-01-02 15:04:05"), e.Room, what)
+}
+
+func ParseEntry(line string) (Entry, error) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		return Entry{}, errors.New("malformed entry")
+	}
+
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("malformed time: %w", err)
+	}
+
+	kind := Kind(fields[1])
+	switch kind {
+	case Join, Leave, Message, Emote, Nick:
+	default:
+		return Entry{}, fmt.Errorf("unknown kind %q", fields[1])
+	}
+
+	return Entry{Time: t, Kind: kind, Room: fields[2], User: fields[3], Text: fields[4]}, nil
+}
+
+// Log appends entries to a file, rotating it as configured. It is not safe
+// for concurrent use.
+type Log struct {
+	path     string
+	maxBytes int64 // 0 for no limit
+	daily    bool
+
+	f       *os.File
+	size    int64
+	started time.Time // when the current file was started
+}
+
+// Open appends to the log at path, creating it if need be.
+func Open(path string, maxBytes int64, daily bool) (*Log, error) {
+	l := &Log{path: path, maxBytes: maxBytes, daily: daily}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open transcript: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open transcript: %w", err)
+	}
+
+	// a file carried over from before a restart was last written to then
+	l.f, l.size, l.started = f, info.Size(), info.ModTime()
+	return nil
+}
+
+// Append writes an entry, first rotating the log if the entry would take it
+// over its size or fall on a new day.
+func (l *Log) Append(e Entry) error {
+	line := e.String() + "\n"
+
+	if l.size > 0 && l.needsRotation(e.Time, len(line)) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.size == 0 {
+		l.started = e.Time
+	}
+
+	n, err := io.WriteString(l.f, line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) needsRotation(t time.Time, n int) bool {
+	if l.maxBytes > 0 && l.size+int64(n) > l.maxBytes {
+		return true
+	}
+	if l.daily {
+		y1, m1, d1 := l.started.UTC().Date()
+		y2, m2, d2 := t.UTC().Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("rotate transcript: %w", err)
+	}
+
+	rotated := l.path + "." + l.started.UTC().Format("20060102T150405")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s.%d", l.path, l.started.UTC().Format("20060102T150405"), i)
+	}
+
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("rotate transcript: %w", err)
+	}
+
+	return l.open()
+}
+
+// Path is where the current file of the log is kept.
+func (l *Log) Path() string {
+	return l.path
+}
+
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Files lists the files of the log at path, oldest first, ending with the
+// current file if there is one.
+func Files(path string) ([]string, error) {
+	rotated, err := filepath.Glob(globEscape(path) + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rotated, func(i, j int) bool {
+		si, ni := rotatedKey(path, rotated[i])
+		sj, nj := rotatedKey(path, rotated[j])
+		return si < sj || (si == sj && ni < nj)
+	})
+
+	if _, err := os.Stat(path); err == nil {
+		rotated = append(rotated, path)
+	}
+	return rotated, nil
+}
+
+// rotatedKey splits the name of a rotated file into when it was started and
+// the suffix added for files started in the same second, 0 for none.
+func rotatedKey(path string, name string) (string, int) {
+	started, suffix, _ := strings.Cut(strings.TrimPrefix(name, path+"."), ".")
+	n, _ := strconv.Atoi(suffix)
+	return started, n
+}
+
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Each calls fn for every entry in the log at path, oldest first.
+func Each(path string, fn func(Entry)) error {
+	files, err := Files(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err := eachInFile(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachInFile(name string, fn func(Entry)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		e, err := ParseEntry(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// EachFileNewestFirst calls fn with the entries of each file of the log at
+// path, oldest first within a file but newest file first, until fn returns
+// false. Only one file's entries are held at a time.
+func EachFileNewestFirst(path string, fn func([]Entry) bool) error {
+	files, err := Files(path)
+	if err != nil {
+		return err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		var entries []Entry
+		if err := eachInFile(files[i], func(e Entry) {
+			entries = append(entries, e)
+		}); err != nil {
+			return err
+		}
+		if !fn(entries) {
+			break
+		}
+	}
+	return nil
+}
+
+// Filter selects entries by user, room, time and text. Zero fields match
+// anything.
+type Filter struct {
+	User     string
+	Room     string
+	Since    time.Time
+	Until    time.Time
+	Contains string
+}
+
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.User != "" && e.User != f.User:
+		return false
+	case f.Room != "" && e.Room != f.Room:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.Contains != "" && !strings.Contains(e.Text, f.Contains):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package transcript_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+var start = time.Date(2022, 12, 1, 23, 58, 0, 0, time.UTC)
+
+func entry(minutes int, kind transcript.Kind, user string, text string) transcript.Entry {
+	return transcript.Entry{Time: start.Add(time.Duration(minutes) * time.Minute), Kind: kind, Room: "general", User: user, Text: text}
+}
+
+func all(t *testing.T, path string) []transcript.Entry {
+	var entries []transcript.Entry
+	if err := transcript.Each(path, func(e transcript.Entry) { entries = append(entries, e) }); err != nil {
+		t.Fatalf("read transcript: %s", err)
+	}
+	return entries
+}
+
+func TestParseEntry(t *testing.T) {
+	is := is.New(t)
+
+	e := entry(0, transcript.Message, "Alice", "tabs\tare\tkept")
+	parsed, err := transcript.ParseEntry(e.String())
+	is.NoErr(err)
+	is.Equal(parsed, e)
+
+	for _, line := range []string{
+		"",
+		"2022-12-01T12:00:00Z\tmessage\tgeneral\tAlice",
+		"yesterday\tmessage\tgeneral\tAlice\thi",
+		"2022-12-01T12:00:00Z\tshout\tgeneral\tAlice\thi",
+	} {
+		_, err := transcript.ParseEntry(line)
+		is.True(err != nil) // malformed
+	}
+}
+
+func TestRotation(t *testing.T) {
+	tt := []struct {
+		name      string
+		maxBytes  int64
+		daily     bool
+		wantFiles []string
+	}{
+		{name: "None", wantFiles: []string{"t.log"}},
+		{name: "Daily", daily: true, wantFiles: []string{"t.log.20221201T235800", "t.log"}},
+		{
+			// each entry is 60 bytes or so, so files hold two entries
+			name:      "Size",
+			maxBytes:  130,
+			wantFiles: []string{"t.log.20221201T235800", "t.log.20221202T000000", "t.log"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "t.log")
+
+			l, err := transcript.Open(path, tc.maxBytes, tc.daily)
+			is.NoErr(err)
+
+			entries := []transcript.Entry{
+				entry(0, transcript.Join, "Alice", ""),
+				entry(1, transcript.Message, "Alice", "Nearly midnight"),
+				entry(2, transcript.Message, "Alice", "Midnight!"),
+				entry(3, transcript.Emote, "Alice", "yawns"),
+				entry(4, transcript.Leave, "Alice", ""),
+			}
+			for _, e := range entries {
+				is.NoErr(l.Append(e))
+			}
+			is.NoErr(l.Close())
+
+			files, err := transcript.Files(path)
+			is.NoErr(err)
+			is.Equal(len(files), len(tc.wantFiles))
+			for i := range files {
+				is.Equal(filepath.Base(files[i]), tc.wantFiles[i])
+			}
+
+			is.Equal(all(t, path), entries) // every entry, in order, across files
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "t.log")
+
+	l, err := transcript.Open(path, 0, false)
+	is.NoErr(err)
+	is.NoErr(l.Append(entry(0, transcript.Message, "Alice", "Before")))
+	is.NoErr(l.Close())
+
+	l, err = transcript.Open(path, 0, false)
+	is.NoErr(err)
+	is.NoErr(l.Append(entry(1, transcript.Message, "Alice", "After")))
+	is.NoErr(l.Close())
+
+	is.Equal(len(all(t, path)), 2) // appended, not truncated
+
+	is.NoErr(os.WriteFile(path, []byte("garbage\n"), 0o644))
+	is.True(transcript.Each(path, func(transcript.Entry) {}) != nil) // malformed
+}
+
+func TestEachFileNewestFirst(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "t.log")
+	l, err := transcript.Open(path, 130, false)
+	is.NoErr(err)
+
+	for i, text := range []string{"one", "two", "three", "four", "five"} {
+		is.NoErr(l.Append(entry(i, transcript.Message, "Alice", text)))
+		is.NoErr(l.Append(entry(i, transcript.Join, "Bob", "")))
+	}
+	is.NoErr(l.Close())
+
+	var texts []string
+	is.NoErr(transcript.EachFileNewestFirst(path, func(entries []transcript.Entry) bool {
+		for _, e := range entries {
+			if e.Kind == transcript.Message {
+				texts = append(texts, e.Text)
+			}
+		}
+		return len(texts) < 3
+	}))
+	is.Equal(texts, []string{"five", "four", "three"}) // newest file first, stopping when asked
+
+	files := 0
+	is.NoErr(transcript.EachFileNewestFirst(path, func([]transcript.Entry) bool {
+		files++
+		return true
+	}))
+	is.Equal(files, 5) // one message and join per file
+
+	is.NoErr(transcript.EachFileNewestFirst(filepath.Join(t.TempDir(), "missing.log"), func([]transcript.Entry) bool {
+		t.Error("called for a missing log")
+		return true
+	}))
+}
+
+func TestFilter(t *testing.T) {
+	e := entry(0, transcript.Message, "Alice", "Hello, world")
+
+	tt := []struct {
+		name   string
+		filter transcript.Filter
+		want   bool
+	}{
+		{name: "Empty", filter: transcript.Filter{}, want: true},
+		{name: "User", filter: transcript.Filter{User: "Alice"}, want: true},
+		{name: "Other User", filter: transcript.Filter{User: "Bob"}, want: false},
+		{name: "Other Room", filter: transcript.Filter{Room: "dev"}, want: false},
+		{name: "Contains", filter: transcript.Filter{Contains: "world"}, want: true},
+		{name: "Does Not Contain", filter: transcript.Filter{Contains: "World"}, want: false},
+		{name: "Since", filter: transcript.Filter{Since: start}, want: true},
+		{name: "Too Early", filter: transcript.Filter{Until: start.Add(-time.Second)}, want: false},
+		{name: "Too Late", filter: transcript.Filter{Since: start.Add(time.Second)}, want: false},
+		{name: "Within", filter: transcript.Filter{Since: start.Add(-time.Hour), Until: start, User: "Alice"}, want: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is.New(t).Equal(tc.filter.Match(e), tc.want)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/budget-chat/transcript"
+)
+
+func TestTranscript(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	clock := &fakeClock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "transcript.log")
+
+	newServer := func() *ChatServer {
+		l, err := transcript.Open(path, 0, false)
+		m.is.NoErr(err)
+		t.Cleanup(func() { l.Close() })
+
+		s := NewChatServer(5000)
+		s.hub.now = clock.Now
+		s.hub.history = historyConfig{lines: 2}
+		s.hub.transcript = l
+		s.hub.restoreHistory()
+		return s
+	}
+
+	s := newServer()
+
+	aliceClientConn, aliceServerConn := net.Pipe()
+	bobClientConn, bobServerConn := net.Pipe()
+	defer aliceClientConn.Close()
+	defer bobClientConn.Close()
+
+	serveTestConns(s, aliceServerConn, bobServerConn)
+
+	alice := bufio.NewScanner(aliceClientConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	join(m, aliceClientConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	said := []struct {
+		line     string
+		expected string
+	}{
+		{line: "one", expected: "[Alice] one"},
+		{line: "two", expected: "[Alice] two"},
+		{line: "/me waves", expected: "* Alice waves"},
+		{line: "/msg Bob Not for the record", expected: "[Alice -> Bob] Not for the record"},
+	}
+	for _, say := range said {
+		clock.Advance(time.Minute)
+		aliceClientConn.Write([]byte(say.line + "\n"))
+		// wait for each line before moving the clock on
+		m.assert(bob, say.expected)
+	}
+	m.assert(alice, "[Alice -> Bob] Not for the record")
+
+	bobClientConn.Close()
+	m.assert(alice, "* Bob has left the room")
+
+	var entries []string
+	m.is.NoErr(transcript.Each(path, func(e transcript.Entry) {
+		entries = append(entries, e.String())
+	}))
+	m.is.Equal(entries, []string{
+		"2022-12-01T12:00:00Z\tjoin\tgeneral\tAlice\t",
+		"2022-12-01T12:00:00Z\tjoin\tgeneral\tBob\t",
+		"2022-12-01T12:01:00Z\tmessage\tgeneral\tAlice\tone",
+		"2022-12-01T12:02:00Z\tmessage\tgeneral\tAlice\ttwo",
+		"2022-12-01T12:03:00Z\temote\tgeneral\tAlice\twaves",
+		"2022-12-01T12:04:00Z\tleave\tgeneral\tBob\t",
+	})
+
+	// a restarted server replays the history kept in the transcript
+	s = newServer()
+
+	charlieClientConn, charlieServerConn := net.Pipe()
+	defer charlieClientConn.Close()
+	serveTestConns(s, charlieServerConn)
+
+	charlie := bufio.NewScanner(charlieClientConn)
+	join(m, charlieClientConn, charlie, "Charlie", "")
+	m.assert(charlie, "* history 12:02:00 [Alice] two")
+	m.assert(charlie, "* history 12:03:00 * Alice waves")
+}
+
+func TestRestoreHistory(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "transcript.log")
+	l, err := transcript.Open(path, 130, false) // a file or two per line
+	if err != nil {
+		t.Fatal(err)
+	}
+	said := []transcript.Entry{
+		{Kind: transcript.Message, Room: "dev", User: "Bob", Text: "early"},
+		{Kind: transcript.Message, Room: "general", User: "Alice", Text: "one"},
+		{Kind: transcript.Message, Room: "general", User: "Alice", Text: "two"},
+		{Kind: transcript.Emote, Room: "general", User: "Alice", Text: "waves"},
+		{Kind: transcript.Message, Room: "general", User: "Alice", Text: "three"},
+	}
+	for i, e := range said {
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { l.Close() })
+
+	lines := func(h *hub, room string) []string {
+		var lines []string
+		if history, ok := h.histories[room]; ok {
+			for _, e := range history.entries {
+				lines = append(lines, strings.TrimSuffix(e.line, "\n"))
+			}
+		}
+		return lines
+	}
+
+	testCases := []struct {
+		name     string
+		history  historyConfig
+		expected map[string][]string
+	}{
+		{
+			name:    "lines",
+			history: historyConfig{lines: 2},
+			expected: map[string][]string{
+				"general": {"* Alice waves", "[Alice] three"},
+				"dev":     nil, // older files are not read once general is full
+			},
+		},
+		{
+			name:    "age",
+			history: historyConfig{age: 200 * time.Second},
+			expected: map[string][]string{
+				"general": {"[Alice] two", "* Alice waves", "[Alice] three"},
+				"dev":     nil,
+			},
+		},
+		{
+			name:    "everything wanted",
+			history: historyConfig{lines: 10},
+			expected: map[string][]string{
+				"general": {"[Alice] one", "[Alice] two", "* Alice waves", "[Alice] three"},
+				"dev":     {"[Bob] early"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := NewChatServer(5000)
+			s.hub.now = func() time.Time { return start.Add(5 * time.Minute) }
+			s.hub.history = tc.history
+			s.hub.transcript = l
+			s.hub.restoreHistory()
+
+			for room, expected := range tc.expected {
+				is.Equal(lines(s.hub, room), expected) // restored room history
+			}
+		})
+	}
+}
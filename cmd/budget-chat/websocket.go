@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/russellslater/protohackers/internal/websocket"
+)
+
+// wsConn presents a WebSocket as the line-based stream TCP clients use, so
+// browsers are served by the same code. Each message received is a line, and
+// each line sent is a text message of its own, without the newline.
+type wsConn struct {
+	*websocket.Conn
+	buf []byte // the rest of the message being read
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	if len(c.buf) == 0 {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = append(msg, '\n')
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		if err := c.WriteMessage(websocket.TextMessage, []byte(strings.TrimSuffix(line, "\n"))); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// webSocketHandler turns each WebSocket connection into a chat client.
+func (s *ChatServer) webSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Upgrade(w, r)
+		if err != nil {
+			log.Printf("websocket from %s: %s\n", r.RemoteAddr, err)
+			return
+		}
+
+		client, err := s.connect(&wsConn{Conn: ws}, lineProtocol{})
+		if err != nil {
+			log.Printf("connection from %s refused: %s\n", r.RemoteAddr, err)
+			return
+		}
+
+		if err := s.serve(client); err != nil {
+			log.Println(err.Error())
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/russellslater/protohackers/internal/websocket"
+)
+
+// dialWebSocket connects to the chat as a browser would, reading and writing
+// lines through the same adapter the server uses.
+func dialWebSocket(t *testing.T, srv *httptest.Server) (*wsConn, *bufio.Scanner) {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	ws, err := websocket.Client(conn, "localhost", "/")
+	if err != nil {
+		conn.Close()
+		t.Fatalf("handshake: %s", err)
+	}
+
+	c := &wsConn{Conn: ws}
+	t.Cleanup(func() { c.Close() })
+
+	return c, bufio.NewScanner(c)
+}
+
+func TestWebSocketClients(t *testing.T) {
+	t.Parallel()
+	m := newMessageExpecter(t)
+
+	s := NewChatServer(5000)
+	srv := httptest.NewServer(s.webSocketHandler())
+	defer srv.Close()
+
+	aliceConn, alice := dialWebSocket(t, srv)
+
+	bobClientConn, bobServerConn := net.Pipe()
+	defer bobClientConn.Close()
+	serveTestConns(s, bobServerConn)
+	bob := bufio.NewScanner(bobClientConn)
+
+	// browsers and TCP clients share the handshake and the room
+	join(m, aliceConn, alice, "Alice", "")
+	join(m, bobClientConn, bob, "Bob", "Alice")
+	m.assert(alice, "* Bob has entered the room")
+
+	aliceConn.Write([]byte("Hi from the browser\n"))
+	m.assert(bob, "[Alice] Hi from the browser")
+
+	bobClientConn.Write([]byte("Hi from nc\n"))
+	m.assert(alice, "[Bob] Hi from nc")
+
+	// multi-line replies arrive as a message per line
+	aliceConn.Write([]byte("/who\n"))
+	m.assert(alice, "* Users online: 2")
+	for _, name := range []string{"Alice", "Bob"} {
+		alice.Scan()
+		m.is.True(strings.HasPrefix(alice.Text(), "* "+name+" (general): connected ")) // a line per user
+	}
+
+	charlieConn, charlie := dialWebSocket(t, srv)
+	m.assert(charlie, "Welcome to budgetchat! What shall I call you?")
+	charlieConn.Write([]byte("Bob\n"))
+	m.assert(charlie, "invalid name: Bob")
+	m.is.True(!charlie.Scan()) // closed after an invalid name
+
+	aliceConn.Close()
+	m.assert(bob, "* Alice has left the room")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
+)
+
+// openOp, sent as the first message of a connection when named assets are
+// enabled, attaches the connection to the shared prices of a named asset. The
+// 8 bytes following the opcode hold the name, padded with trailing zero bytes.
+const openOp = 'A'
+
+var errInvalidAssetName = errors.New("invalid asset name")
+
+// asset is a price series shared between connections. Every message that
+// changes its prices is appended to a log of 9 byte messages, which is
+// replayed to restore the series when the server restarts.
+type asset struct {
+	name   string
+	prices *pricestore.PriceStore
+	log    *os.File
+	sync.Mutex
+}
+
+type assetRegistry struct {
+	dir    string
+	assets map[string]*asset
+	sync.Mutex
+}
+
+func newAssetRegistry(dir string) (*assetRegistry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("data dir: %w", err)
+	}
+
+	return &assetRegistry{
+		dir:    dir,
+		assets: make(map[string]*asset),
+	}, nil
+}
+
+// parseAssetName extracts the name from the fields of an open message.
+func parseAssetName(buf []byte) (string, error) {
+	name := string(bytes.TrimRight(buf, "\x00"))
+	if len(name) == 0 || name[0] == '.' {
+		return "", errInvalidAssetName
+	}
+
+	// names become file names, so only allow characters safe to use as one
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.') {
+			return "", errInvalidAssetName
+		}
+	}
+
+	return name, nil
+}
+
+// open returns the named asset, loading it from disk the first time it is
+// requested.
+func (r *assetRegistry) open(name string, cfg config) (*asset, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if a, ok := r.assets[name]; ok {
+		return a, nil
+	}
+
+	path := filepath.Join(r.dir, name+".dat")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open asset %s: %w", name, err)
+	}
+
+	a := &asset{
+		name:   name,
+		prices: pricestore.NewWithPolicy(cfg.duplicates),
+		log:    f,
+	}
+
+	if err := a.replay(cfg); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay asset %s: %w", name, err)
+	}
+
+	log.Printf("opened asset %s [# prices: %d]\n", name, a.prices.Len())
+
+	r.assets[name] = a
+
+	return a, nil
+}
+
+// replay applies the logged messages, discarding any incomplete message left
+// at the end by an interrupted write.
+func (a *asset) replay(cfg config) error {
+	// the log only holds messages that were applied, which may include
+	// extended opcodes
+	cfg.extended = true
+
+	buf := make([]byte, 9)
+
+	var offset int64
+	for {
+		_, err := io.ReadFull(a.log, buf)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			if err := a.log.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		t, arg1, arg2 := parseCommand(buf)
+		if _, err := executeCommand(t, arg1, arg2, a.prices, cfg); err != nil {
+			// the limits may have been lowered since the message was logged
+			log.Printf("replay asset %s: %s", a.name, err)
+		}
+
+		offset += int64(len(buf))
+	}
+
+	_, err := a.log.Seek(offset, io.SeekStart)
+	return err
+}
+
+// execute runs a message against the shared prices, logging it if it changes
+// them.
+func (a *asset) execute(buf []byte, cfg config) ([]byte, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	t, arg1, arg2 := parseCommand(buf)
+	res, err := executeCommand(t, arg1, arg2, a.prices, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if isMutating(t, cfg) {
+		if _, err := a.log.Write(buf); err != nil {
+			return nil, fmt.Errorf("log asset %s: %w", a.name, err)
+		}
+	}
+
+	return res, nil
+}
+
+func isMutating(t rune, cfg config) bool {
+	switch t {
+	case 'I':
+		return true
+	case deleteOp, clearOp:
+		return cfg.extended
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
+)
+
+// Extended opcodes, enabled with the -extended flag, use the same 9 byte
+// message as 'I' and 'Q'. Queries take a mintime and maxtime and respond with
+// a 4 byte big endian int32, which is 0 when no prices fall in range. The sum
+// of a range can exceed 32 bits, so 'S' is the one exception and responds with
+// an 8 byte big endian int64. Deleting and clearing send no response.
+//
+// Exporting streams the prices in range as a 4 byte big endian count followed
+// by that many 'I' messages in timestamp order, so that the output can be
+// replayed into another session as is.
+const (
+	minOp    = 'N' // lowest price in range
+	maxOp    = 'X' // highest price in range
+	medianOp = 'M' // median price in range, the mean of the middle two when even
+	countOp  = 'C' // number of prices in range
+	sumOp    = 'S' // total of the prices in range (8 byte response)
+	deleteOp = 'D' // remove the price at the timestamp in the first field
+	clearOp  = 'R' // remove all prices in the session, both fields ignored
+	exportOp = 'E' // every price in range as 'I' messages
+)
+
+func executeExtendedCommand(t rune, arg1 int32, arg2 int32, prices *pricestore.PriceStore) []byte {
+	switch t {
+	case minOp:
+		min, _ := prices.Min(arg1, arg2)
+		return int32Response(min)
+	case maxOp:
+		max, _ := prices.Max(arg1, arg2)
+		return int32Response(max)
+	case medianOp:
+		return int32Response(prices.Median(arg1, arg2))
+	case countOp:
+		return int32Response(int32(prices.Count(arg1, arg2)))
+	case sumOp:
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(prices.Sum(arg1, arg2)))
+		return bs
+	case exportOp:
+		return exportPrices(arg1, arg2, prices)
+	case deleteOp:
+		prices.Delete(arg1)
+	case clearOp:
+		prices.Clear()
+	}
+
+	return nil
+}
+
+func exportPrices(mintime int32, maxtime int32, prices *pricestore.PriceStore) []byte {
+	count := prices.Count(mintime, maxtime)
+
+	bs := make([]byte, 4, 4+9*count)
+	binary.BigEndian.PutUint32(bs, uint32(count))
+
+	prices.Each(mintime, maxtime, func(timestamp int32, price int32) {
+		bs = append(bs, 'I')
+		bs = binary.BigEndian.AppendUint32(bs, uint32(timestamp))
+		bs = binary.BigEndian.AppendUint32(bs, uint32(price))
+	})
+
+	return bs
+}
+
+func int32Response(n int32) []byte {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint32(bs, uint32(n))
+	return bs
+}
@@ -0,0 +1,166 @@
+// Command framecsv converts between CSV and the 9 byte messages understood by
+// means-to-an-end.
+//
+// Each CSV record of two fields, a timestamp and a price, becomes an 'I'
+// message. A record of three fields starts with the opcode instead, so that
+// queries can be sent too, e.g. "Q,12288,16384". A record of "A" and an asset
+// name, e.g. "A,ACME", becomes the 'A' message opening that named asset. Lines
+// starting with # are ignored.
+//
+// With -csv the conversion runs the other way, turning 'I' messages back into
+// two field records, 'A' messages back into "A" and the name, and any other
+// message into three. Use -export when
+// reading the response to an 'E' message, which is preceded by a 4 byte count.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// openOp is the opcode of the message that opens a named asset, whose name
+// fills the remaining 8 bytes.
+const openOp = 'A'
+
+func main() {
+	toCSV := flag.Bool("csv", false, "Convert messages on stdin to CSV (CSV is converted to messages otherwise)")
+	export := flag.Bool("export", false, "Expect the 4 byte count that precedes an export response (with -csv)")
+	flag.Parse()
+
+	var err error
+	if *toCSV {
+		err = writeCSV(os.Stdout, os.Stdin, *export)
+	} else {
+		err = writeMessages(os.Stdout, os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeMessages converts CSV records read from r into messages written to w.
+func writeMessages(w io.Writer, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg, err := parseRecord(record)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func parseRecord(record []string) ([]byte, error) {
+	op := byte('I')
+
+	switch len(record) {
+	case 2:
+		if record[0] == string(openOp) {
+			return openMessage(record[1])
+		}
+	case 3:
+		if len(record[0]) != 1 {
+			return nil, fmt.Errorf("opcode %q is not a single character", record[0])
+		}
+		op, record = record[0][0], record[1:]
+	default:
+		return nil, fmt.Errorf("want 2 or 3 fields, got %d", len(record))
+	}
+
+	msg := []byte{op}
+	for _, field := range record {
+		n, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint32(msg, uint32(n))
+	}
+
+	return msg, nil
+}
+
+// openMessage builds the message opening the named asset, padding the name
+// with zero bytes.
+func openMessage(name string) ([]byte, error) {
+	if name == "" || len(name) > 8 || strings.ContainsRune(name, 0) {
+		return nil, fmt.Errorf("asset name %q is not 1 to 8 bytes", name)
+	}
+
+	msg := make([]byte, 9)
+	msg[0] = openOp
+	copy(msg[1:], name)
+	return msg, nil
+}
+
+// writeCSV converts messages read from r into CSV records written to w.
+func writeCSV(w io.Writer, r io.Reader, export bool) error {
+	if export {
+		// the count is implied by the messages that follow
+		if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+			return fmt.Errorf("read export count: %w", err)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+
+	buf := make([]byte, 9)
+	for {
+		_, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("input ends part way through a message")
+		}
+		if err != nil {
+			return err
+		}
+
+		if buf[0] == openOp {
+			if err := cw.Write([]string{string(openOp), string(bytes.TrimRight(buf[1:], "\x00"))}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		arg1 := strconv.Itoa(int(int32(binary.BigEndian.Uint32(buf[1:5]))))
+		arg2 := strconv.Itoa(int(int32(binary.BigEndian.Uint32(buf[5:]))))
+
+		record := []string{arg1, arg2}
+		if buf[0] != 'I' {
+			record = []string{string(buf[:1]), arg1, arg2}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
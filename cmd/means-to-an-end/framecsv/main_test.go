@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	in, err := os.ReadFile("../test/data.csv")
+	is.NoErr(err)
+
+	var msgs bytes.Buffer
+	is.NoErr(writeMessages(&msgs, bytes.NewReader(in)))
+	is.Equal(msgs.Len(), 5*9)
+	is.Equal(msgs.Bytes()[:9], []byte{'I', 0x00, 0x00, 0x30, 0x39, 0x00, 0x00, 0x00, 0x65})
+
+	var out bytes.Buffer
+	is.NoErr(writeCSV(&out, &msgs, false))
+	is.Equal(out.String(), "12345,101\n12346,102\n12347,100\n40960,5\nQ,12288,16384\n")
+}
+
+func TestOpenAsset(t *testing.T) {
+	is := is.New(t)
+
+	var msgs bytes.Buffer
+	is.NoErr(writeMessages(&msgs, strings.NewReader("A,ACME\n12345,101\n")))
+	is.Equal(msgs.Bytes()[:9], []byte{'A', 'A', 'C', 'M', 'E', 0x00, 0x00, 0x00, 0x00})
+
+	var out bytes.Buffer
+	is.NoErr(writeCSV(&out, &msgs, false))
+	is.Equal(out.String(), "A,ACME\n12345,101\n")
+}
+
+func TestExportResponse(t *testing.T) {
+	is := is.New(t)
+
+	res := []byte{0x00, 0x00, 0x00, 0x01, 'I', 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x07}
+
+	var out bytes.Buffer
+	is.NoErr(writeCSV(&out, bytes.NewReader(res), true))
+	is.Equal(out.String(), "-1,7\n")
+}
+
+func TestInvalidInput(t *testing.T) {
+	tt := []struct {
+		name string
+		csv  string
+	}{
+		{name: "Too Few Fields", csv: "1\n"},
+		{name: "Too Many Fields", csv: "I,1,2,3\n"},
+		{name: "Long Opcode", csv: "IQ,1,2\n"},
+		{name: "Not A Number", csv: "1,ten\n"},
+		{name: "Out Of Range", csv: "1,2147483648\n"},
+		{name: "Empty Asset Name", csv: "A,\n"},
+		{name: "Long Asset Name", csv: "A,ABCDEFGHI\n"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeMessages(&out, strings.NewReader(tc.csv)); err == nil {
+				t.Errorf("converted %q, want error", tc.csv)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if err := writeCSV(&out, bytes.NewReader([]byte{'I', 0x00}), false); err == nil {
+		t.Errorf("converted a partial message, want error")
+	}
+}
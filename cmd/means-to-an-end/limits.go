@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
+)
+
+// storedPrices is the number of prices held across all sessions and assets,
+// published at /debug/vars when the debug listener is enabled.
+var storedPrices = expvar.NewInt("storedPrices")
+
+var errLimitExceeded = errors.New("price limit exceeded")
+
+// limitPolicy decides what happens when an insert takes a session over its
+// limit.
+type limitPolicy int
+
+const (
+	rejectPolicy     limitPolicy = iota // refuse the insert and disconnect
+	evictPolicy                         // remove the oldest timestamps
+	downsamplePolicy                    // merge neighbouring prices
+)
+
+func parseLimitPolicy(s string) (limitPolicy, error) {
+	switch s {
+	case "reject":
+		return rejectPolicy, nil
+	case "evict":
+		return evictPolicy, nil
+	case "downsample":
+		return downsamplePolicy, nil
+	}
+	return 0, fmt.Errorf("unknown limit policy %q", s)
+}
+
+type limits struct {
+	maxEntries int // 0 for no limit
+	maxBytes   int // 0 for no limit
+	policy     limitPolicy
+}
+
+// maxPrices combines the entry and byte caps into a number of prices, or 0
+// when there is no limit.
+func (l limits) maxPrices() int {
+	max := l.maxEntries
+	if l.maxBytes > 0 {
+		byBytes := l.maxBytes / pricestore.EntryBytes
+		if byBytes < 1 {
+			byBytes = 1
+		}
+		if max == 0 || byBytes < max {
+			max = byBytes
+		}
+	}
+	return max
+}
+
+// admit refuses an insert that would take a session over its limit under the
+// reject policy.
+func (l limits) admit(timestamp int32, prices *pricestore.PriceStore) error {
+	max := l.maxPrices()
+	if l.policy != rejectPolicy || max == 0 || prices.Len() < max {
+		return nil
+	}
+
+	if prices.WouldAdd(timestamp) {
+		return errLimitExceeded
+	}
+	return nil
+}
+
+// enforce brings a session back within its limit after an insert.
+func (l limits) enforce(prices *pricestore.PriceStore) {
+	max := l.maxPrices()
+	if max == 0 {
+		return
+	}
+
+	switch l.policy {
+	case evictPolicy:
+		for prices.Len() > max {
+			prices.DeleteOldest()
+		}
+	case downsamplePolicy:
+		for prices.Len() > max {
+			prices.Downsample()
+		}
+	}
+}
@@ -2,33 +2,106 @@ package main
 
 import (
 	"encoding/binary"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 
 	"github.com/russellslater/protohackers"
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
 )
 
+type config struct {
+	extended bool           // accept the opcodes in extended.go
+	assets   *assetRegistry // nil unless named assets are enabled
+	limits   limits         // caps on the prices held by each session
+
+	duplicates pricestore.DuplicatePolicy // treatment of prices inserted for a timestamp twice
+}
+
 func main() {
-	log.Fatal(protohackers.ListenAndAccept(5000, handle))
+	var cfg config
+	var dataDir, policy, duplicates, debugAddr string
+	flag.BoolVar(&cfg.extended, "extended", false, "Accept extended query opcodes (min, max, median, count, sum, delete, clear)")
+	flag.StringVar(&dataDir, "data-dir", "", "Directory to persist named assets in (named assets are disabled when empty)")
+	flag.IntVar(&cfg.limits.maxEntries, "max-entries", 0, "Maximum prices held per session (unlimited when 0)")
+	flag.IntVar(&cfg.limits.maxBytes, "max-bytes", 0, "Maximum approximate bytes of prices held per session (unlimited when 0)")
+	flag.StringVar(&policy, "limit-policy", "reject", "Action when a session exceeds its limit: reject, evict or downsample")
+	flag.StringVar(&duplicates, "duplicates", "overwrite", "Action when a timestamp is inserted twice: overwrite, keep-first, reject or keep-all")
+	flag.StringVar(&debugAddr, "debug-addr", "", "Address to serve /debug/vars on, including the number of stored prices (disabled when empty)")
+	flag.Parse()
+
+	var err error
+	if cfg.limits.policy, err = parseLimitPolicy(policy); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.duplicates, err = parseDuplicatePolicy(duplicates); err != nil {
+		log.Fatal(err)
+	}
+
+	if dataDir != "" {
+		if cfg.assets, err = newAssetRegistry(dataDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if debugAddr != "" {
+		go func() {
+			// expvar registers /debug/vars on the default mux
+			log.Fatal(http.ListenAndServe(debugAddr, nil))
+		}()
+	}
+
+	log.Fatal(protohackers.ListenAndAccept(5000, func(c net.Conn) error {
+		return handle(c, cfg)
+	}))
 }
 
-func handle(c net.Conn) error {
+func handle(c net.Conn, cfg config) error {
 	defer c.Close()
 
-	prices := make(map[int32]int32)
+	// prices are private to the connection unless it opens a named asset
+	prices := pricestore.NewWithPolicy(cfg.duplicates)
+	var shared *asset
+
+	defer func() {
+		storedPrices.Add(-int64(prices.Len()))
+	}()
 
 	// each message from a client is 9 bytes long
 	buf := make([]byte, 9)
 
-	for {
+	for first := true; ; first = false {
 		n, err := io.ReadFull(c, buf)
 		if err != nil || n != len(buf) {
 			return err
 		}
 
 		t, arg1, arg2 := parseCommand(buf)
-		res := executeCommand(t, arg1, arg2, prices)
+
+		if first && t == openOp && cfg.assets != nil {
+			name, err := parseAssetName(buf[1:])
+			if err != nil {
+				return err
+			}
+			if shared, err = cfg.assets.open(name, cfg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var res []byte
+		if shared != nil {
+			if res, err = shared.execute(buf, cfg); err != nil {
+				return err
+			}
+		} else {
+			if res, err = executeCommand(t, arg1, arg2, prices, cfg); err != nil {
+				return err
+			}
+		}
 
 		if res != nil {
 			if _, err := c.Write(res); err != nil {
@@ -38,6 +111,20 @@ func handle(c net.Conn) error {
 	}
 }
 
+func parseDuplicatePolicy(s string) (pricestore.DuplicatePolicy, error) {
+	switch s {
+	case "overwrite":
+		return pricestore.Overwrite, nil
+	case "keep-first":
+		return pricestore.KeepFirst, nil
+	case "reject":
+		return pricestore.Reject, nil
+	case "keep-all":
+		return pricestore.KeepAll, nil
+	}
+	return 0, fmt.Errorf("unknown duplicate policy %q", s)
+}
+
 func parseCommand(buf []byte) (rune, int32, int32) {
 	t := rune(buf[0])
 	arg1 := int32(binary.BigEndian.Uint32(buf[1:5]))
@@ -45,38 +132,39 @@ func parseCommand(buf []byte) (rune, int32, int32) {
 	return t, arg1, arg2
 }
 
-func executeCommand(t rune, arg1 int32, arg2 int32, prices map[int32]int32) []byte {
+func executeCommand(t rune, arg1 int32, arg2 int32, prices *pricestore.PriceStore, cfg config) ([]byte, error) {
+	before := prices.Len()
+	defer func() {
+		storedPrices.Add(int64(prices.Len() - before))
+	}()
+
 	switch t {
 	case 'I':
-		insertPrice(arg1, arg2, prices)
+		return nil, insertPrice(arg1, arg2, prices, cfg.limits)
 	case 'Q':
 		mean := queryPrice(arg1, arg2, prices)
-		bs := make([]byte, 4)
-		binary.BigEndian.PutUint32(bs, uint32(mean))
-		return bs
+		return int32Response(mean), nil
 	}
 
-	return nil
-}
+	if cfg.extended {
+		return executeExtendedCommand(t, arg1, arg2, prices), nil
+	}
 
-func insertPrice(timestamp int32, price int32, prices map[int32]int32) {
-	prices[timestamp] = price
+	// unknown opcodes are ignored
+	return nil, nil
 }
 
-func queryPrice(mintime int32, maxtime int32, prices map[int32]int32) int32 {
-	var total int64 // int64 to avoid overflow
-	var count int64
-	for time, p := range prices {
-		if time >= mintime && time <= maxtime {
-			total += int64(p)
-			count++
-		}
+func insertPrice(timestamp int32, price int32, prices *pricestore.PriceStore, lim limits) error {
+	if err := lim.admit(timestamp, prices); err != nil {
+		return err
 	}
-
-	if count == 0 {
-		return 0
+	if err := prices.Insert(timestamp, price); err != nil {
+		return err
 	}
+	lim.enforce(prices)
+	return nil
+}
 
-	// integer division; "acceptable to round either up or down, at the server's discretion"
-	return int32(total / count)
+func queryPrice(mintime int32, maxtime int32, prices *pricestore.PriceStore) int32 {
+	return prices.Mean(mintime, maxtime)
 }
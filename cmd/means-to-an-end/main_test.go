@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
+	"github.com/russellslater/protohackers/internal/scenario"
+)
+
+func message(t rune, arg1 int32, arg2 int32) []byte {
+	buf := make([]byte, 9)
+	buf[0] = byte(t)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(arg1))
+	binary.BigEndian.PutUint32(buf[5:], uint32(arg2))
+	return buf
+}
+
+// connectTo returns a scenario connect func that serves each connection the
+// scenario opens with cfg.
+func connectTo(cfg config) func(string) (net.Conn, error) {
+	return func(string) (net.Conn, error) {
+		clientConn, serverConn := net.Pipe()
+		go handle(serverConn, cfg)
+		return clientConn, nil
+	}
+}
+
+func runScenario(t *testing.T, path string, cfg config) {
+	sc, err := scenario.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sc.Run(connectTo(cfg)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	tt := []struct {
+		file   string
+		cfg    config
+		assets bool // open a registry in a temporary data dir
+	}{
+		{file: "example.scenario"},
+		{file: "unknown-opcodes-ignored.scenario"},
+		{file: "extended-aggregates.scenario", cfg: config{extended: true}},
+		{file: "extended-empty-range.scenario", cfg: config{extended: true}},
+		{file: "extended-sum-exceeds-32-bits.scenario", cfg: config{extended: true}},
+		{file: "extended-delete-and-clear.scenario", cfg: config{extended: true}},
+		{file: "extended-export.scenario", cfg: config{extended: true}},
+		{file: "named-assets.scenario", cfg: config{extended: true}, assets: true},
+		{file: "named-assets-disabled.scenario"},
+		{file: "invalid-asset-name.scenario", assets: true},
+		{file: "limit-reject.scenario", cfg: config{limits: limits{maxEntries: 1, policy: rejectPolicy}}},
+		{file: "duplicate-reject.scenario", cfg: config{duplicates: pricestore.Reject}},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(strings.TrimSuffix(tc.file, ".scenario"), func(t *testing.T) {
+			if tc.assets {
+				assets, err := newAssetRegistry(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				tc.cfg.assets = assets
+			}
+
+			runScenario(t, filepath.Join("testdata", tc.file), tc.cfg)
+		})
+	}
+}
+
+func TestNamedAssetsSurviveRestart(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+
+	assets, err := newAssetRegistry(dir)
+	is.NoErr(err)
+	runScenario(t, filepath.Join("testdata", "named-assets.scenario"), config{extended: true, assets: assets})
+
+	restarted, err := newAssetRegistry(dir)
+	is.NoErr(err)
+	runScenario(t, filepath.Join("testdata", "named-assets-restarted.scenario"), config{extended: true, assets: restarted})
+}
+
+func TestReplayDiscardsPartialMessage(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+
+	msgs := append(message('I', 1, 10), message('I', 2, 20)...)
+	msgs = append(msgs, message('I', 3, 30)[:5]...)
+	is.NoErr(os.WriteFile(filepath.Join(dir, "ACME.dat"), msgs, 0o644))
+
+	assets, err := newAssetRegistry(dir)
+	is.NoErr(err)
+
+	a, err := assets.open("ACME", config{})
+	is.NoErr(err)
+	is.Equal(a.prices.Len(), 2)
+
+	// later messages are appended after the last complete one
+	_, err = a.execute(message('I', 4, 40), config{})
+	is.NoErr(err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "ACME.dat"))
+	is.NoErr(err)
+	is.Equal(len(data), 27)
+}
+
+func TestLimits(t *testing.T) {
+	type insert struct {
+		timestamp int32
+		price     int32
+		wantErr   error
+	}
+
+	tt := []struct {
+		name      string
+		limits    limits
+		inserts   []insert
+		wantCount int32
+		wantMean  int32
+	}{
+		{
+			name:   "Unlimited",
+			limits: limits{},
+			inserts: []insert{
+				{timestamp: 1, price: 10},
+				{timestamp: 2, price: 20},
+				{timestamp: 3, price: 30},
+			},
+			wantCount: 3,
+			wantMean:  20,
+		},
+		{
+			name:   "Reject",
+			limits: limits{maxEntries: 2, policy: rejectPolicy},
+			inserts: []insert{
+				{timestamp: 1, price: 10},
+				{timestamp: 2, price: 20},
+				{timestamp: 2, price: 40}, // overwriting does not grow the session
+				{timestamp: 3, price: 30, wantErr: errLimitExceeded},
+			},
+			wantCount: 2,
+			wantMean:  25,
+		},
+		{
+			name:   "Evict Oldest",
+			limits: limits{maxEntries: 2, policy: evictPolicy},
+			inserts: []insert{
+				{timestamp: 2, price: 20},
+				{timestamp: 3, price: 30},
+				{timestamp: 4, price: 40},
+				{timestamp: 1, price: 10}, // older than everything kept, so evicted at once
+			},
+			wantCount: 2,
+			wantMean:  35,
+		},
+		{
+			name:   "Downsample",
+			limits: limits{maxEntries: 3, policy: downsamplePolicy},
+			inserts: []insert{
+				{timestamp: 1, price: 10},
+				{timestamp: 2, price: 20},
+				{timestamp: 3, price: 30},
+				{timestamp: 4, price: 40},
+			},
+			wantCount: 2,
+			wantMean:  25,
+		},
+		{
+			name:   "Max Bytes",
+			limits: limits{maxBytes: 2 * pricestore.EntryBytes, policy: rejectPolicy},
+			inserts: []insert{
+				{timestamp: 1, price: 10},
+				{timestamp: 2, price: 20},
+				{timestamp: 3, price: 30, wantErr: errLimitExceeded},
+			},
+			wantCount: 2,
+			wantMean:  15,
+		},
+		{
+			name:   "Tightest Limit Wins",
+			limits: limits{maxEntries: 10, maxBytes: pricestore.EntryBytes, policy: evictPolicy},
+			inserts: []insert{
+				{timestamp: 1, price: 10},
+				{timestamp: 2, price: 20},
+			},
+			wantCount: 1,
+			wantMean:  20,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			prices := pricestore.New()
+			cfg := config{extended: true, limits: tc.limits}
+
+			for _, ins := range tc.inserts {
+				_, err := executeCommand('I', ins.timestamp, ins.price, prices, cfg)
+				is.Equal(err, ins.wantErr)
+			}
+
+			res, err := executeCommand(countOp, 0, 10, prices, cfg)
+			is.NoErr(err)
+			is.Equal(res, int32Response(tc.wantCount))
+
+			res, err = executeCommand('Q', 0, 10, prices, cfg)
+			is.NoErr(err)
+			is.Equal(res, int32Response(tc.wantMean))
+		})
+	}
+}
+
+func TestDuplicatePolicies(t *testing.T) {
+	tt := []struct {
+		name       string
+		duplicates pricestore.DuplicatePolicy
+		limits     limits
+		wantErr    error
+		wantCount  int32
+		wantMean   int32
+	}{
+		{name: "Overwrite", duplicates: pricestore.Overwrite, wantCount: 2, wantMean: 25},
+		{name: "Keep First", duplicates: pricestore.KeepFirst, wantCount: 2, wantMean: 15},
+		{name: "Reject", duplicates: pricestore.Reject, wantErr: pricestore.ErrDuplicateTimestamp, wantCount: 2, wantMean: 15},
+		{name: "Keep All", duplicates: pricestore.KeepAll, wantCount: 3, wantMean: 20},
+		{
+			name:       "Keep All Counts Towards Limit",
+			duplicates: pricestore.KeepAll,
+			limits:     limits{maxEntries: 2, policy: rejectPolicy},
+			wantErr:    errLimitExceeded,
+			wantCount:  2,
+			wantMean:   15,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			cfg := config{extended: true, limits: tc.limits, duplicates: tc.duplicates}
+			prices := pricestore.NewWithPolicy(cfg.duplicates)
+
+			_, err := executeCommand('I', 1, 10, prices, cfg)
+			is.NoErr(err)
+			_, err = executeCommand('I', 2, 20, prices, cfg)
+			is.NoErr(err)
+
+			_, err = executeCommand('I', 1, 30, prices, cfg)
+			is.Equal(err, tc.wantErr)
+
+			res, err := executeCommand(countOp, 0, 10, prices, cfg)
+			is.NoErr(err)
+			is.Equal(res, int32Response(tc.wantCount))
+
+			res, err = executeCommand('Q', 0, 10, prices, cfg)
+			is.NoErr(err)
+			is.Equal(res, int32Response(tc.wantMean))
+		})
+	}
+}
@@ -0,0 +1,457 @@
+// Package pricestore keeps asset prices ordered by timestamp so that range
+// queries run in O(log n).
+//
+// Prices are held in a treap, a binary search tree on timestamps whose nodes
+// are also heap ordered on random priorities to keep it balanced. Each node
+// records the sum, count, minimum and maximum of its subtree, so that the
+// prices before any timestamp can be aggregated by walking a single path from
+// the root.
+package pricestore
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// EntryBytes approximates the memory used to store a single price.
+const EntryBytes = int(unsafe.Sizeof(node{}))
+
+// ErrDuplicateTimestamp is returned when inserting a price for a timestamp that
+// already has one under the Reject policy.
+var ErrDuplicateTimestamp = errors.New("duplicate timestamp")
+
+// DuplicatePolicy decides how a price inserted for a timestamp that already
+// has one is treated.
+type DuplicatePolicy int
+
+const (
+	Overwrite DuplicatePolicy = iota // replace the stored price
+	KeepFirst                        // ignore the new price
+	Reject                           // refuse the new price with ErrDuplicateTimestamp
+	KeepAll                          // keep every price, each counting towards queries
+)
+
+// Under KeepAll each duplicate is a node of its own, so timestamps are only
+// ordered loosely: those in a left subtree are at most, and those in a right
+// subtree at least, the timestamp of their parent.
+type node struct {
+	timestamp int32
+	price     int32
+	priority  uint64
+	left      *node
+	right     *node
+
+	// aggregates over the subtree rooted at this node
+	count int64
+	sum   int64
+	min   int32
+	max   int32
+}
+
+func (n *node) update() {
+	n.count, n.sum = 1, int64(n.price)
+	n.min, n.max = n.price, n.price
+	for _, c := range []*node{n.left, n.right} {
+		if c == nil {
+			continue
+		}
+		n.count += c.count
+		n.sum += c.sum
+		if c.min < n.min {
+			n.min = c.min
+		}
+		if c.max > n.max {
+			n.max = c.max
+		}
+	}
+}
+
+type PriceStore struct {
+	root   *node
+	seed   uint64
+	policy DuplicatePolicy
+}
+
+// New returns a store that overwrites prices inserted for duplicate
+// timestamps.
+func New() *PriceStore {
+	return NewWithPolicy(Overwrite)
+}
+
+func NewWithPolicy(policy DuplicatePolicy) *PriceStore {
+	return &PriceStore{seed: 0x9e3779b97f4a7c15, policy: policy}
+}
+
+// nextPriority is a xorshift generator; priorities only need to be well
+// spread, not unpredictable.
+func (s *PriceStore) nextPriority() uint64 {
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 7
+	s.seed ^= s.seed << 17
+	return s.seed
+}
+
+// Len returns the number of stored prices.
+func (s *PriceStore) Len() int {
+	if s.root == nil {
+		return 0
+	}
+	return int(s.root.count)
+}
+
+// Insert stores the price for a timestamp, applying the store's policy if the
+// timestamp already has a price.
+func (s *PriceStore) Insert(timestamp int32, price int32) error {
+	if s.policy == Reject && s.find(timestamp) != nil {
+		return ErrDuplicateTimestamp
+	}
+
+	s.root = s.insert(s.root, timestamp, price)
+	return nil
+}
+
+// WouldAdd reports whether inserting a price for the timestamp would add to
+// the number of stored prices.
+func (s *PriceStore) WouldAdd(timestamp int32) bool {
+	return s.policy == KeepAll || s.find(timestamp) == nil
+}
+
+func (s *PriceStore) find(timestamp int32) *node {
+	n := s.root
+	for n != nil && n.timestamp != timestamp {
+		if timestamp < n.timestamp {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return n
+}
+
+func (s *PriceStore) insert(n *node, timestamp int32, price int32) *node {
+	if n == nil {
+		nn := &node{timestamp: timestamp, price: price, priority: s.nextPriority()}
+		nn.update()
+		return nn
+	}
+
+	switch {
+	case timestamp < n.timestamp:
+		n.left = s.insert(n.left, timestamp, price)
+		if n.left.priority > n.priority {
+			n = rotateRight(n)
+		}
+	case timestamp > n.timestamp || s.policy == KeepAll:
+		n.right = s.insert(n.right, timestamp, price)
+		if n.right.priority > n.priority {
+			n = rotateLeft(n)
+		}
+	case s.policy == Overwrite:
+		n.price = price
+	}
+
+	n.update()
+	return n
+}
+
+// Delete removes the prices stored for a timestamp, reporting whether there
+// were any.
+func (s *PriceStore) Delete(timestamp int32) bool {
+	var deleted bool
+	for {
+		var ok bool
+		if s.root, ok = s.delete(s.root, timestamp); !ok {
+			return deleted
+		}
+		deleted = true
+	}
+}
+
+// delete removes a single node with the timestamp.
+func (s *PriceStore) delete(n *node, timestamp int32) (*node, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var deleted bool
+	switch {
+	case timestamp < n.timestamp:
+		n.left, deleted = s.delete(n.left, timestamp)
+	case timestamp > n.timestamp:
+		n.right, deleted = s.delete(n.right, timestamp)
+	default:
+		return merge(n.left, n.right), true
+	}
+
+	n.update()
+	return n, deleted
+}
+
+// DeleteOldest removes the prices with the earliest timestamp, reporting
+// whether there were any.
+func (s *PriceStore) DeleteOldest() bool {
+	if s.root == nil {
+		return false
+	}
+
+	n := s.root
+	for n.left != nil {
+		n = n.left
+	}
+	return s.Delete(n.timestamp)
+}
+
+// Downsample halves the number of stored prices by merging each pair of
+// neighbouring prices into one, stored at the earlier timestamp with the mean
+// of the two prices. With an odd number of prices the latest is kept as is.
+func (s *PriceStore) Downsample() {
+	type entry struct {
+		timestamp int32
+		price     int32
+	}
+
+	entries := make([]entry, 0, s.Len())
+	s.each(s.root, math.MinInt32, math.MaxInt32, func(timestamp int32, price int32) {
+		entries = append(entries, entry{timestamp: timestamp, price: price})
+	})
+
+	s.root = nil
+	for i := 0; i < len(entries); i += 2 {
+		e := entries[i]
+		if i+1 < len(entries) {
+			e.price = int32((int64(e.price) + int64(entries[i+1].price)) / 2)
+		}
+		// merged timestamps repeat only under KeepAll, which keeps each of
+		// them, so every merged price is stored
+		s.root = s.insert(s.root, e.timestamp, e.price)
+	}
+}
+
+// Clear removes all prices.
+func (s *PriceStore) Clear() {
+	s.root = nil
+}
+
+// merge joins two treaps where every timestamp in a precedes those in b.
+func merge(a *node, b *node) *node {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		a.right = merge(a.right, b)
+		a.update()
+		return a
+	}
+
+	b.left = merge(a, b.left)
+	b.update()
+	return b
+}
+
+func rotateRight(n *node) *node {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func rotateLeft(n *node) *node {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// Mean returns the mean of the prices with timestamps between mintime and
+// maxtime inclusive, or 0 if there are none.
+func (s *PriceStore) Mean(mintime int32, maxtime int32) int32 {
+	sum, count := s.sumCount(mintime, maxtime)
+	if count == 0 {
+		return 0
+	}
+
+	// integer division; "acceptable to round either up or down, at the server's discretion"
+	return int32(sum / count)
+}
+
+// Sum returns the total of the prices with timestamps between mintime and
+// maxtime inclusive.
+func (s *PriceStore) Sum(mintime int32, maxtime int32) int64 {
+	sum, _ := s.sumCount(mintime, maxtime)
+	return sum
+}
+
+// Count returns the number of prices with timestamps between mintime and
+// maxtime inclusive.
+func (s *PriceStore) Count(mintime int32, maxtime int32) int64 {
+	_, count := s.sumCount(mintime, maxtime)
+	return count
+}
+
+// sumCount totals the prices with timestamps between mintime and maxtime
+// inclusive.
+func (s *PriceStore) sumCount(mintime int32, maxtime int32) (int64, int64) {
+	if mintime > maxtime {
+		return 0, 0
+	}
+
+	hiSum, hiCount := s.below(int64(maxtime) + 1)
+	loSum, loCount := s.below(int64(mintime))
+
+	return hiSum - loSum, hiCount - loCount
+}
+
+// below totals the prices with timestamps strictly before t.
+func (s *PriceStore) below(t int64) (sum int64, count int64) {
+	for n := s.root; n != nil; {
+		if int64(n.timestamp) < t {
+			if n.left != nil {
+				sum += n.left.sum
+				count += n.left.count
+			}
+			sum += int64(n.price)
+			count++
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return sum, count
+}
+
+// Min returns the lowest price with a timestamp between mintime and maxtime
+// inclusive, or false if there are none.
+func (s *PriceStore) Min(mintime int32, maxtime int32) (int32, bool) {
+	return s.extreme(mintime, maxtime, false)
+}
+
+// Max returns the highest price with a timestamp between mintime and maxtime
+// inclusive, or false if there are none.
+func (s *PriceStore) Max(mintime int32, maxtime int32) (int32, bool) {
+	return s.extreme(mintime, maxtime, true)
+}
+
+// extreme finds the lowest, or highest when max is set, price in range. The
+// walk descends to the first node in range and then along the paths to each
+// bound, taking whole subtrees that fall inside the range from their
+// aggregates.
+func (s *PriceStore) extreme(mintime int32, maxtime int32, max bool) (int32, bool) {
+	if mintime > maxtime {
+		return 0, false
+	}
+
+	n := s.root
+	for n != nil && (n.timestamp < mintime || n.timestamp > maxtime) {
+		if n.timestamp < mintime {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	if n == nil {
+		return 0, false
+	}
+
+	best := n.price
+	consider := func(price int32) {
+		if (max && price > best) || (!max && price < best) {
+			best = price
+		}
+	}
+	subtree := func(c *node) {
+		if c == nil {
+			return
+		}
+		if max {
+			consider(c.max)
+		} else {
+			consider(c.min)
+		}
+	}
+
+	for m := n.left; m != nil; {
+		if m.timestamp >= mintime {
+			consider(m.price)
+			subtree(m.right)
+			m = m.left
+		} else {
+			m = m.right
+		}
+	}
+
+	for m := n.right; m != nil; {
+		if m.timestamp <= maxtime {
+			consider(m.price)
+			subtree(m.left)
+			m = m.right
+		} else {
+			m = m.left
+		}
+	}
+
+	return best, true
+}
+
+// Median returns the median of the prices with timestamps between mintime and
+// maxtime inclusive, or 0 if there are none. The mean of the two middle prices
+// is used for an even number of prices. Unlike the other queries this is
+// O(k log k) in the number of prices in range, as they must all be visited.
+func (s *PriceStore) Median(mintime int32, maxtime int32) int32 {
+	if mintime > maxtime {
+		return 0
+	}
+
+	var prices []int32
+	s.each(s.root, mintime, maxtime, func(timestamp int32, price int32) {
+		prices = append(prices, price)
+	})
+
+	if len(prices) == 0 {
+		return 0
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	mid := len(prices) / 2
+	if len(prices)%2 == 1 {
+		return prices[mid]
+	}
+	return int32((int64(prices[mid-1]) + int64(prices[mid])) / 2)
+}
+
+// Each calls fn for each price with a timestamp between mintime and maxtime
+// inclusive, in timestamp order.
+func (s *PriceStore) Each(mintime int32, maxtime int32, fn func(timestamp int32, price int32)) {
+	if mintime > maxtime {
+		return
+	}
+	s.each(s.root, mintime, maxtime, fn)
+}
+
+// each visits the prices with timestamps between mintime and maxtime inclusive
+// in timestamp order.
+func (s *PriceStore) each(n *node, mintime int32, maxtime int32, fn func(timestamp int32, price int32)) {
+	if n == nil {
+		return
+	}
+	// duplicates of a bound may lie on either side
+	if n.timestamp >= mintime {
+		s.each(n.left, mintime, maxtime, fn)
+	}
+	if n.timestamp >= mintime && n.timestamp <= maxtime {
+		fn(n.timestamp, n.price)
+	}
+	if n.timestamp <= maxtime {
+		s.each(n.right, mintime, maxtime, fn)
+	}
+}
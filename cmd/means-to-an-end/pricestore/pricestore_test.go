@@ -0,0 +1,349 @@
+package pricestore_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/means-to-an-end/pricestore"
+)
+
+func TestMean(t *testing.T) {
+	is := is.New(t)
+
+	s := pricestore.New()
+	s.Insert(12345, 101)
+	s.Insert(12346, 102)
+	s.Insert(12347, 100)
+	s.Insert(40960, 5)
+
+	is.Equal(s.Len(), 4)
+	is.Equal(s.Mean(12288, 16384), int32(101))
+	is.Equal(s.Mean(12345, 12345), int32(101))
+	is.Equal(s.Mean(0, 12344), int32(0))     // no prices in range
+	is.Equal(s.Mean(16384, 12288), int32(0)) // mintime after maxtime
+	is.Equal(s.Mean(math.MinInt32, math.MaxInt32), int32(77))
+}
+
+func TestDuplicateTimestampOverwrites(t *testing.T) {
+	is := is.New(t)
+
+	s := pricestore.New()
+	s.Insert(100, 10)
+	s.Insert(200, 20)
+	s.Insert(100, 30)
+
+	is.Equal(s.Len(), 2)
+	is.Equal(s.Mean(100, 100), int32(30))
+	is.Equal(s.Mean(0, 300), int32(25))
+}
+
+func TestDuplicatePolicies(t *testing.T) {
+	tt := []struct {
+		name       string
+		policy     pricestore.DuplicatePolicy
+		wantErr    error
+		wantLen    int
+		wantMean   int32
+		wantMedian int32
+	}{
+		{name: "Overwrite", policy: pricestore.Overwrite, wantLen: 2, wantMean: 45, wantMedian: 45},
+		{name: "Keep First", policy: pricestore.KeepFirst, wantLen: 2, wantMean: 15, wantMedian: 15},
+		{name: "Reject", policy: pricestore.Reject, wantErr: pricestore.ErrDuplicateTimestamp, wantLen: 2, wantMean: 15, wantMedian: 15},
+		{name: "Keep All", policy: pricestore.KeepAll, wantLen: 4, wantMean: 40, wantMedian: 40},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := pricestore.NewWithPolicy(tc.policy)
+			is.NoErr(s.Insert(100, 10))
+			is.NoErr(s.Insert(200, 20))
+
+			is.Equal(s.WouldAdd(100), tc.policy == pricestore.KeepAll)
+			is.Equal(s.WouldAdd(300), true)
+
+			is.Equal(s.Insert(100, 60), tc.wantErr)
+			if tc.wantErr == nil {
+				is.NoErr(s.Insert(100, 70))
+			}
+
+			is.Equal(s.Len(), tc.wantLen)
+			is.Equal(s.Mean(0, 300), tc.wantMean)
+			is.Equal(s.Median(0, 300), tc.wantMedian)
+
+			is.True(s.Delete(100)) // all prices at the timestamp are deleted
+			is.Equal(s.Len(), 1)
+		})
+	}
+}
+
+func TestNegativeValues(t *testing.T) {
+	is := is.New(t)
+
+	s := pricestore.New()
+	s.Insert(-100, -10)
+	s.Insert(-50, -30)
+	s.Insert(math.MaxInt32, math.MaxInt32)
+	s.Insert(math.MaxInt32-1, math.MaxInt32)
+
+	is.Equal(s.Mean(-100, -50), int32(-20))
+	is.Equal(s.Mean(0, math.MaxInt32), int32(math.MaxInt32)) // no overflow while summing
+}
+
+func TestDeleteOldest(t *testing.T) {
+	is := is.New(t)
+
+	s := pricestore.New()
+	is.Equal(s.DeleteOldest(), false) // nothing to delete
+
+	s.Insert(300, 30)
+	s.Insert(100, 10)
+	s.Insert(200, 20)
+
+	is.True(s.DeleteOldest())
+	is.Equal(s.Len(), 2)
+	is.Equal(s.Count(100, 100), int64(0))
+	is.Equal(s.Mean(0, 1000), int32(25))
+}
+
+func TestDownsample(t *testing.T) {
+	is := is.New(t)
+
+	s := pricestore.New()
+	for i := int32(1); i <= 5; i++ {
+		s.Insert(i*100, i*10)
+	}
+
+	s.Downsample()
+
+	is.Equal(s.Len(), 3)
+	is.Equal(s.Mean(100, 100), int32(15)) // 10 and 20 merged
+	is.Equal(s.Mean(300, 300), int32(35)) // 30 and 40 merged
+	is.Equal(s.Mean(500, 500), int32(50)) // odd one out kept
+	is.Equal(s.Count(200, 200), int64(0))
+}
+
+// mapStore is the map scan that the price store replaced; it serves as the
+// reference implementation and the benchmark baseline.
+type mapStore map[int32]int32
+
+func (m mapStore) Mean(mintime int32, maxtime int32) int32 {
+	var total int64
+	var count int64
+	for time, p := range m {
+		if time >= mintime && time <= maxtime {
+			total += int64(p)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return int32(total / count)
+}
+
+func (m mapStore) inRange(mintime int32, maxtime int32) []int32 {
+	var prices []int32
+	for time, p := range m {
+		if time >= mintime && time <= maxtime {
+			prices = append(prices, p)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+	return prices
+}
+
+func (m mapStore) Median(mintime int32, maxtime int32) int32 {
+	prices := m.inRange(mintime, maxtime)
+	switch {
+	case len(prices) == 0:
+		return 0
+	case len(prices)%2 == 1:
+		return prices[len(prices)/2]
+	}
+	return int32((int64(prices[len(prices)/2-1]) + int64(prices[len(prices)/2])) / 2)
+}
+
+func (m mapStore) Sum(mintime int32, maxtime int32) int64 {
+	var sum int64
+	for _, p := range m.inRange(mintime, maxtime) {
+		sum += int64(p)
+	}
+	return sum
+}
+
+// multiMapStore is the reference for KeepAll, holding every price inserted for
+// a timestamp.
+type multiMapStore map[int32][]int32
+
+func (m multiMapStore) inRange(mintime int32, maxtime int32) []int32 {
+	var prices []int32
+	for time, ps := range m {
+		if time >= mintime && time <= maxtime {
+			prices = append(prices, ps...)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+	return prices
+}
+
+func TestKeepAllMatchesMapScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+
+	s := pricestore.NewWithPolicy(pricestore.KeepAll)
+	m := make(multiMapStore)
+
+	for i := 0; i < 3000; i++ {
+		// a very narrow range of timestamps forces many duplicates
+		timestamp, price := rnd.Int31n(200)-100, rnd.Int31n(10000)
+		is.New(t).NoErr(s.Insert(timestamp, price))
+		m[timestamp] = append(m[timestamp], price)
+
+		if i%5 == 0 {
+			deleted := rnd.Int31n(200) - 100
+			s.Delete(deleted)
+			delete(m, deleted)
+		}
+
+		mintime, maxtime := rnd.Int31n(220)-110, rnd.Int31n(220)-110
+		prices := m.inRange(mintime, maxtime)
+
+		var sum int64
+		for _, p := range prices {
+			sum += int64(p)
+		}
+
+		if got := s.Sum(mintime, maxtime); got != sum {
+			t.Fatalf("Sum(%d, %d) = %d, want %d", mintime, maxtime, got, sum)
+		}
+		if got := s.Count(mintime, maxtime); got != int64(len(prices)) {
+			t.Fatalf("Count(%d, %d) = %d, want %d", mintime, maxtime, got, len(prices))
+		}
+
+		var median int32
+		switch {
+		case len(prices) == 0:
+		case len(prices)%2 == 1:
+			median = prices[len(prices)/2]
+		default:
+			median = int32((int64(prices[len(prices)/2-1]) + int64(prices[len(prices)/2])) / 2)
+		}
+		if got := s.Median(mintime, maxtime); got != median {
+			t.Fatalf("Median(%d, %d) = %d, want %d", mintime, maxtime, got, median)
+		}
+
+		if len(prices) > 0 {
+			min, _ := s.Min(mintime, maxtime)
+			max, _ := s.Max(mintime, maxtime)
+			if min != prices[0] || max != prices[len(prices)-1] {
+				t.Fatalf("Min/Max(%d, %d) = %d/%d, want %d/%d", mintime, maxtime, min, max, prices[0], prices[len(prices)-1])
+			}
+		}
+	}
+}
+
+func TestMatchesMapScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	s := pricestore.New()
+	m := make(mapStore)
+
+	for i := 0; i < 5000; i++ {
+		// a narrow range of timestamps forces duplicates
+		timestamp, price := rnd.Int31n(2000)-1000, rnd.Int31()-math.MaxInt32/2
+		s.Insert(timestamp, price)
+		m[timestamp] = price
+
+		if i%3 == 0 {
+			deleted := rnd.Int31n(2000) - 1000
+			_, ok := m[deleted]
+			if s.Delete(deleted) != ok {
+				t.Fatalf("Delete(%d) = %t, want %t", deleted, !ok, ok)
+			}
+			delete(m, deleted)
+		}
+
+		mintime, maxtime := rnd.Int31n(2200)-1100, rnd.Int31n(2200)-1100
+		if got, want := s.Mean(mintime, maxtime), m.Mean(mintime, maxtime); got != want {
+			t.Fatalf("Mean(%d, %d) = %d, want %d", mintime, maxtime, got, want)
+		}
+		if got, want := s.Median(mintime, maxtime), m.Median(mintime, maxtime); got != want {
+			t.Fatalf("Median(%d, %d) = %d, want %d", mintime, maxtime, got, want)
+		}
+		if got, want := s.Sum(mintime, maxtime), m.Sum(mintime, maxtime); got != want {
+			t.Fatalf("Sum(%d, %d) = %d, want %d", mintime, maxtime, got, want)
+		}
+
+		prices := m.inRange(mintime, maxtime)
+		if got, want := s.Count(mintime, maxtime), int64(len(prices)); got != want {
+			t.Fatalf("Count(%d, %d) = %d, want %d", mintime, maxtime, got, want)
+		}
+
+		min, minOK := s.Min(mintime, maxtime)
+		max, maxOK := s.Max(mintime, maxtime)
+		if minOK != (len(prices) > 0) || maxOK != (len(prices) > 0) {
+			t.Fatalf("Min/Max(%d, %d) found = %t/%t, want %t", mintime, maxtime, minOK, maxOK, len(prices) > 0)
+		}
+		if len(prices) > 0 && (min != prices[0] || max != prices[len(prices)-1]) {
+			t.Fatalf("Min/Max(%d, %d) = %d/%d, want %d/%d", mintime, maxtime, min, max, prices[0], prices[len(prices)-1])
+		}
+	}
+
+	if s.Len() != len(m) {
+		t.Errorf("got %d prices, want %d", s.Len(), len(m))
+	}
+
+	s.Clear()
+	if s.Len() != 0 || s.Mean(math.MinInt32, math.MaxInt32) != 0 {
+		t.Errorf("prices remain after Clear")
+	}
+}
+
+var benchSizes = []int{1000, 10000, 100000, 500000}
+
+func BenchmarkMean(b *testing.B) {
+	for _, size := range benchSizes {
+		s := pricestore.New()
+		for i := 0; i < size; i++ {
+			s.Insert(int32(i), int32(i%1000))
+		}
+
+		b.Run(fmt.Sprintf("store/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Mean(int32(size/4), int32(size/2))
+			}
+		})
+	}
+}
+
+func BenchmarkMeanMapScan(b *testing.B) {
+	for _, size := range benchSizes {
+		m := make(mapStore)
+		for i := 0; i < size; i++ {
+			m[int32(i)] = int32(i % 1000)
+		}
+
+		b.Run(fmt.Sprintf("map/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Mean(int32(size/4), int32(size/2))
+			}
+		})
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	s := pricestore.New()
+
+	for i := 0; i < b.N; i++ {
+		s.Insert(rnd.Int31(), rnd.Int31())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/russellslater/protohackers/cmd/prime-time/codec"
+)
+
+// the first byte sent on the binary listener selects the encoding
+const (
+	msgpackFormat = 'M'
+	cborFormat    = 'C'
+)
+
+// frames are prefixed with their length as a big endian uint32
+const maxFrameBytes = 1 << 20
+
+// frameHandler produces the encoded response to a single request frame and
+// whether the request was valid
+type frameHandler func(c codec.Codec, frame []byte) ([]byte, bool, error)
+
+func handleBinary(conn net.Conn) error {
+	return serveFrames(conn, handleFrame)
+}
+
+func handleBinaryVerbose(conn net.Conn) error {
+	return serveFrames(conn, handleFrameVerbose)
+}
+
+func serveFrames(conn net.Conn, handleFrame frameHandler) error {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	format, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	var c codec.Codec
+	switch format {
+	case msgpackFormat:
+		c = codec.MessagePack{}
+	case cborFormat:
+		c = codec.CBOR{}
+	default:
+		// without an agreed encoding the plain text response is all we can send
+		conn.Write([]byte("invalid request\n"))
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	log.Printf("%s connection from %s", c.Name(), conn.RemoteAddr())
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var resBytes []byte
+		var valid bool
+
+		if size := binary.BigEndian.Uint32(header); size > maxFrameBytes {
+			// an empty frame is never a valid request, so it yields the
+			// malformed response without reading the oversized frame
+			resBytes, _, err = handleFrame(c, nil)
+		} else {
+			frame := make([]byte, size)
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				return err
+			}
+
+			log.Printf("received: % x", frame)
+
+			resBytes, valid, err = handleFrame(c, frame)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := writeFrame(conn, resBytes); err != nil {
+			return err
+		}
+
+		// stop processing if the request was invalid
+		if !valid {
+			break
+		}
+	}
+
+	return nil
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+func handleFrame(c codec.Codec, frame []byte) ([]byte, bool, error) {
+	req, reqErr := parseFrame(c, frame)
+	if reqErr != nil {
+		resBytes, err := c.Encode("invalid request")
+		return resBytes, false, err
+	}
+
+	return respondFrame(c, req)
+}
+
+// handleFrameVerbose replies to a malformed request with a map naming the
+// failure category and the byte offset within the frame.
+func handleFrameVerbose(c codec.Codec, frame []byte) ([]byte, bool, error) {
+	req, reqErr := parseFrame(c, frame)
+	if reqErr != nil {
+		res := map[string]interface{}{"error": reqErr.Category, "offset": reqErr.Offset}
+		if reqErr.Field != "" {
+			res["field"] = reqErr.Field
+		}
+		resBytes, err := c.Encode(res)
+		return resBytes, false, err
+	}
+
+	return respondFrame(c, req)
+}
+
+func respondFrame(c codec.Codec, req primeRequest) ([]byte, bool, error) {
+	resBytes, err := c.Encode(map[string]interface{}{"method": "isPrime", "prime": isPrime(*req.Number)})
+	if err != nil {
+		return nil, true, err
+	}
+	return resBytes, true, nil
+}
+
+func parseFrame(c codec.Codec, frame []byte) (primeRequest, *requestError) {
+	decoded, err := c.DecodeMap(frame)
+	if err != nil {
+		var syntaxErr *codec.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return primeRequest{}, &requestError{Category: badEncoding, Offset: int64(syntaxErr.Offset)}
+		}
+		return primeRequest{}, &requestError{Category: wrongType, Offset: 0}
+	}
+
+	fields := make(requestFields, len(decoded))
+	for _, f := range decoded {
+		fields[strings.ToLower(f.Key)] = requestField{value: f.Value, offset: int64(f.Offset)}
+	}
+
+	return validateRequest(fields, int64(len(frame)))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/prime-time/codec"
+)
+
+// send writes without blocking the test, as net.Pipe writes wait for a reader
+func send(conn net.Conn, b []byte) {
+	go conn.Write(b)
+}
+
+func frame(payload []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(payload))), payload...)
+}
+
+func readFrame(t *testing.T, r io.Reader, c codec.Codec) interface{} {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("read header: %s", err)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("read payload: %s", err)
+	}
+
+	v, err := c.Decode(payload)
+	if err != nil {
+		t.Fatalf("decode % x: %s", payload, err)
+	}
+	return v
+}
+
+func mustEncode(t *testing.T, c codec.Codec, v interface{}) []byte {
+	b, err := c.Encode(v)
+	if err != nil {
+		t.Fatalf("encode %v: %s", v, err)
+	}
+	return b
+}
+
+func TestBinaryFormats(t *testing.T) {
+	formats := []struct {
+		name   string
+		format byte
+		codec  codec.Codec
+	}{
+		{name: "MessagePack", format: msgpackFormat, codec: codec.MessagePack{}},
+		{name: "CBOR", format: cborFormat, codec: codec.CBOR{}},
+	}
+
+	for _, f := range formats {
+		f := f
+		t.Run(f.name, func(t *testing.T) {
+			is := is.New(t)
+
+			client, server := net.Pipe()
+			defer client.Close()
+
+			go handleBinary(server)
+
+			reader := bufio.NewReader(client)
+
+			first := frame(mustEncode(t, f.codec, map[string]interface{}{"method": "isPrime", "number": 7}))
+			send(client, append([]byte{f.format}, first...))
+			is.Equal(readFrame(t, reader, f.codec), map[string]interface{}{"method": "isPrime", "prime": true})
+
+			send(client, frame(mustEncode(t, f.codec, map[string]interface{}{"method": "isPrime", "number": 8.0})))
+			is.Equal(readFrame(t, reader, f.codec), map[string]interface{}{"method": "isPrime", "prime": false})
+
+			send(client, frame(mustEncode(t, f.codec, map[string]interface{}{"method": "isPrime", "number": "7"})))
+			is.Equal(readFrame(t, reader, f.codec), "invalid request")
+
+			_, err := reader.ReadByte()
+			is.Equal(err, io.EOF) // server hung up after invalid request
+		})
+	}
+}
+
+func TestVerboseFrames(t *testing.T) {
+	c := codec.MessagePack{}
+
+	tt := []struct {
+		name  string
+		frame []byte
+		want  interface{}
+	}{
+		{
+			name:  "Valid Request",
+			frame: mustEncode(t, c, map[string]interface{}{"method": "isPrime", "number": 2}),
+			want:  map[string]interface{}{"method": "isPrime", "prime": true},
+		},
+		{
+			name:  "Bad Encoding",
+			frame: []byte("\x82\xa6method"),
+			want:  map[string]interface{}{"error": badEncoding, "offset": int64(8)},
+		},
+		{
+			name:  "Not A Map",
+			frame: mustEncode(t, c, []interface{}{int64(7)}),
+			want:  map[string]interface{}{"error": wrongType, "offset": int64(0)},
+		},
+		{
+			name:  "Missing Number",
+			frame: mustEncode(t, c, map[string]interface{}{"method": "isPrime"}),
+			want:  map[string]interface{}{"error": missingField, "field": "number", "offset": int64(16)},
+		},
+		{
+			name:  "Wrong Type",
+			frame: mustEncode(t, c, map[string]interface{}{"method": "isPrime", "number": true}),
+			want:  map[string]interface{}{"error": wrongType, "field": "number", "offset": int64(23)},
+		},
+		{
+			name:  "Unknown Method",
+			frame: mustEncode(t, c, map[string]interface{}{"method": "isOdd", "number": 7}),
+			want:  map[string]interface{}{"error": unknownMethod, "field": "method", "offset": int64(8)},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			resBytes, valid, err := handleFrameVerbose(c, tc.frame)
+			if err != nil {
+				t.Fatalf("handle frame: %s", err)
+			}
+
+			got, err := c.Decode(resBytes)
+			if err != nil {
+				t.Fatalf("decode % x: %s", resBytes, err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+
+			_, wantValid := tc.want.(map[string]interface{})["prime"]
+			if valid != wantValid {
+				t.Errorf("got valid %t, want %t", valid, wantValid)
+			}
+		})
+	}
+}
+
+func TestBinaryUnknownFormat(t *testing.T) {
+	is := is.New(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go handleBinary(server)
+
+	send(client, []byte("{\"method\":\"isPrime\",\"number\":7}\n"))
+
+	line, _ := bufio.NewReader(client).ReadString('\n')
+	is.Equal(line, "invalid request\n")
+}
+
+func TestBinaryOversizedFrame(t *testing.T) {
+	is := is.New(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go handleBinary(server)
+
+	header := binary.BigEndian.AppendUint32([]byte{cborFormat}, maxFrameBytes+1)
+	send(client, header)
+
+	reader := bufio.NewReader(client)
+	is.Equal(readFrame(t, reader, codec.CBOR{}), "invalid request")
+
+	_, err := reader.ReadByte()
+	is.Equal(err, io.EOF) // server hung up after invalid request
+}
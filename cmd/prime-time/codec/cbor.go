@@ -0,0 +1,272 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CBOR major types
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// CBOR implements Codec for RFC 8949. Indefinite-length items are not
+// supported and tags are decoded as the item they enclose.
+type CBOR struct{}
+
+func (CBOR) Name() string {
+	return "cbor"
+}
+
+func (CBOR) Decode(b []byte) (interface{}, error) {
+	d := &cborDecoder{decoder{buf: b}}
+	return d.decode(d.value)
+}
+
+func (CBOR) DecodeMap(b []byte) ([]Field, error) {
+	d := &cborDecoder{decoder{buf: b}}
+	return d.decodeMap(d.mapLen, d.value)
+}
+
+type cborDecoder struct {
+	decoder
+}
+
+// head reads the initial byte of an item and its argument.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	major, info = b>>5, b&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		arg, err = d.readUint(1 << (info - 24))
+		return major, info, arg, err
+	case info == 31:
+		return 0, 0, 0, d.errorf("indefinite length items are not supported")
+	}
+
+	return 0, 0, 0, d.errorf("reserved additional information %d", info)
+}
+
+func (d *cborDecoder) mapLen() (uint64, bool, error) {
+	major, _, n, err := d.head()
+	if err != nil {
+		return 0, false, err
+	}
+	return n, major == cborMap, nil
+}
+
+func (d *cborDecoder) value() (interface{}, error) {
+	start := d.pos
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return uintValue(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			d.pos = start
+			return nil, d.errorf("negative integer out of range")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes:
+		return d.read(arg)
+	case cborText:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		return d.arrayValue(arg)
+	case cborMap:
+		return d.mapValue(arg)
+	case cborTag:
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		return d.value()
+	}
+
+	// major type 7: simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null and undefined
+		return nil, nil
+	case 25:
+		return halfToFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+
+	d.pos = start
+	return nil, d.errorf("unsupported simple value %d", arg)
+}
+
+func (d *cborDecoder) arrayValue(n uint64) (interface{}, error) {
+	if err := d.checkCount(n); err != nil {
+		return nil, err
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *cborDecoder) mapValue(n uint64) (interface{}, error) {
+	if err := d.checkCount(n); err != nil {
+		return nil, err
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[keyString(k)] = v
+	}
+	return m, nil
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+func (c CBOR) Encode(v interface{}) ([]byte, error) {
+	return c.encode(nil, v)
+}
+
+func (c CBOR) encode(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xf6), nil
+	case bool:
+		if v {
+			return append(buf, 0xf5), nil
+		}
+		return append(buf, 0xf4), nil
+	case int:
+		return c.encodeInt(buf, int64(v)), nil
+	case int64:
+		return c.encodeInt(buf, v), nil
+	case uint64:
+		return c.encodeHead(buf, cborUint, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, 0xfb), math.Float64bits(v)), nil
+	case string:
+		buf = c.encodeHead(buf, cborText, uint64(len(v)))
+		return append(buf, v...), nil
+	case []interface{}:
+		buf = c.encodeHead(buf, cborArray, uint64(len(v)))
+		for _, e := range v {
+			var err error
+			if buf, err = c.encode(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = c.encodeHead(buf, cborMap, uint64(len(v)))
+		for _, k := range sortedKeys(v) {
+			var err error
+			if buf, err = c.encode(buf, k); err != nil {
+				return nil, err
+			}
+			if buf, err = c.encode(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	return nil, fmt.Errorf("cbor: cannot encode %T", v)
+}
+
+func (c CBOR) encodeInt(buf []byte, n int64) []byte {
+	if n < 0 {
+		return c.encodeHead(buf, cborNegInt, uint64(-1-n))
+	}
+	return c.encodeHead(buf, cborUint, uint64(n))
+}
+
+// encodeHead writes the initial byte of an item using the shortest argument.
+func (CBOR) encodeHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+	}
+}
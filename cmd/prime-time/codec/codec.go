@@ -0,0 +1,206 @@
+// Package codec implements the subset of MessagePack and CBOR needed to carry
+// prime-time requests and responses.
+//
+// Decoded values are nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} or map[string]interface{}. Maps with non-string keys are
+// decoded with their keys formatted as strings.
+package codec
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// nesting deeper than this is rejected rather than risk exhausting the stack
+const maxDepth = 64
+
+type Codec interface {
+	// Name identifies the encoding in logs.
+	Name() string
+	// Decode decodes b, which must hold exactly one value.
+	Decode(b []byte) (interface{}, error)
+	// DecodeMap decodes b, which must hold exactly one map, into its fields.
+	DecodeMap(b []byte) ([]Field, error)
+	// Encode encodes nil, bool, int, int64, uint64, float64, string,
+	// []interface{} and map[string]interface{} values.
+	Encode(v interface{}) ([]byte, error)
+}
+
+// Field is a top-level map entry along with the byte offset at which its value
+// starts.
+type Field struct {
+	Key    string
+	Value  interface{}
+	Offset int
+}
+
+// SyntaxError reports malformed input and the offset at which it was found.
+type SyntaxError struct {
+	Offset int
+	msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+// TypeError reports a well-formed value that is not a map.
+type TypeError struct {
+	Offset int
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("expected map at offset %d", e.Offset)
+}
+
+// decoder holds the state shared by the MessagePack and CBOR decoders.
+type decoder struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func (d *decoder) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Offset: d.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, d.errorf("unexpected end of input")
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, d.errorf("unexpected end of input")
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) readUint(n int) (uint64, error) {
+	b, err := d.read(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// checkCount guards against lengths that could not possibly fit in the
+// remaining input, each element being at least one byte long.
+func (d *decoder) checkCount(n uint64) error {
+	if n > uint64(len(d.buf)-d.pos) {
+		return d.errorf("length %d exceeds input", n)
+	}
+	return nil
+}
+
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return d.errorf("nesting too deep")
+	}
+	return nil
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+// decode decodes a single top-level value of any type.
+func (d *decoder) decode(value func() (interface{}, error)) (interface{}, error) {
+	if len(d.buf) == 0 {
+		return nil, d.errorf("empty input")
+	}
+
+	v, err := value()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(d.buf) {
+		return nil, d.errorf("unexpected trailing data")
+	}
+
+	return v, nil
+}
+
+// decodeMap decodes a top-level map using the format specific functions.
+// mapLen returns the length of the map starting at the current position, or
+// ok == false when the value there is not a map.
+func (d *decoder) decodeMap(mapLen func() (n uint64, ok bool, err error), value func() (interface{}, error)) ([]Field, error) {
+	if len(d.buf) == 0 {
+		return nil, d.errorf("empty input")
+	}
+
+	n, ok, err := mapLen()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// the value must still be well formed to be reported as the wrong type
+		d.pos = 0
+		if _, err := value(); err != nil {
+			return nil, err
+		}
+		return nil, &TypeError{Offset: 0}
+	}
+	if err := d.checkCount(n); err != nil {
+		return nil, err
+	}
+
+	fields := make([]Field, 0, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := value()
+		if err != nil {
+			return nil, err
+		}
+		offset := d.pos
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, Field{Key: keyString(k), Value: v, Offset: offset})
+	}
+
+	if d.pos != len(d.buf) {
+		return nil, d.errorf("unexpected trailing data")
+	}
+
+	return fields, nil
+}
+
+// uintValue prefers int64 so that integers decode to the same type regardless
+// of the width they were encoded with.
+func uintValue(n uint64) interface{} {
+	if n <= math.MaxInt64 {
+		return int64(n)
+	}
+	return n
+}
+
+func keyString(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	return fmt.Sprint(k)
+}
+
+// sortedKeys makes encoded maps deterministic.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+package codec_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/cmd/prime-time/codec"
+)
+
+func TestDecodeMap(t *testing.T) {
+	tt := []struct {
+		name  string
+		codec codec.Codec
+		input []byte
+	}{
+		{
+			name:  "MessagePack",
+			codec: codec.MessagePack{},
+			input: []byte("\x82\xa6method\xa7isPrime\xa6number\x07"),
+		},
+		{
+			name:  "CBOR",
+			codec: codec.CBOR{},
+			input: []byte("\xa2\x66method\x67isPrime\x66number\x07"),
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			fields, err := tc.codec.DecodeMap(tc.input)
+			is.NoErr(err)
+			is.Equal(fields, []codec.Field{
+				{Key: "method", Value: "isPrime", Offset: 8},
+				{Key: "number", Value: int64(7), Offset: 23},
+			})
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil,
+		true,
+		false,
+		int64(0),
+		int64(23),
+		int64(24),
+		int64(127),
+		int64(128),
+		int64(-1),
+		int64(-32),
+		int64(-33),
+		int64(-129),
+		int64(65536),
+		int64(math.MaxInt64),
+		int64(math.MinInt64),
+		uint64(math.MaxUint64),
+		1.5,
+		-0.25,
+		"",
+		"isPrime",
+		string(bytes.Repeat([]byte("a"), 300)),
+		string(bytes.Repeat([]byte("b"), 70000)),
+		[]interface{}{int64(1), "two", 3.5, []interface{}{}},
+		map[string]interface{}{"nested": map[string]interface{}{"prime": true}},
+	}
+
+	for _, c := range []codec.Codec{codec.MessagePack{}, codec.CBOR{}} {
+		c := c
+		t.Run(c.Name(), func(t *testing.T) {
+			for _, want := range values {
+				b, err := c.Encode(map[string]interface{}{"v": want})
+				if err != nil {
+					t.Fatalf("encode %v: %s", want, err)
+				}
+
+				fields, err := c.DecodeMap(b)
+				if err != nil {
+					t.Fatalf("decode %v: %s", want, err)
+				}
+
+				if len(fields) != 1 || !reflect.DeepEqual(fields[0].Value, want) {
+					t.Errorf("got %#v, want %#v", fields, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCBORFloats(t *testing.T) {
+	tt := []struct {
+		input []byte
+		want  float64
+	}{
+		{input: []byte{0xf9, 0x3c, 0x00}, want: 1.0},
+		{input: []byte{0xf9, 0xc4, 0x00}, want: -4.0},
+		{input: []byte{0xf9, 0x00, 0x01}, want: 5.960464477539063e-8},
+		{input: []byte{0xf9, 0x7c, 0x00}, want: math.Inf(1)},
+		{input: []byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, want: 100000.0},
+		{input: []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, want: 1.1},
+	}
+
+	for _, tc := range tt {
+		input := append([]byte{0xa1, 0x61, 'n'}, tc.input...)
+
+		fields, err := codec.CBOR{}.DecodeMap(input)
+		if err != nil {
+			t.Fatalf("decode % x: %s", tc.input, err)
+		}
+		if fields[0].Value != tc.want {
+			t.Errorf("got %v, want %v", fields[0].Value, tc.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tt := []struct {
+		name       string
+		codec      codec.Codec
+		input      []byte
+		wantType   bool
+		wantOffset int
+	}{
+		{name: "MessagePack Empty", codec: codec.MessagePack{}, input: []byte{}, wantOffset: 0},
+		{name: "MessagePack Truncated", codec: codec.MessagePack{}, input: []byte("\x81\xa6meth"), wantOffset: 2},
+		{name: "MessagePack Trailing Data", codec: codec.MessagePack{}, input: []byte("\x80\x01"), wantOffset: 1},
+		{name: "MessagePack Never Used", codec: codec.MessagePack{}, input: []byte("\x81\xa1n\xc1"), wantOffset: 3},
+		{name: "MessagePack Huge Map", codec: codec.MessagePack{}, input: []byte("\xdf\xff\xff\xff\xff"), wantOffset: 5},
+		{name: "MessagePack Not A Map", codec: codec.MessagePack{}, input: []byte("\x92\x01\x02"), wantType: true},
+		{name: "CBOR Empty", codec: codec.CBOR{}, input: []byte{}, wantOffset: 0},
+		{name: "CBOR Truncated", codec: codec.CBOR{}, input: []byte("\xa1\x66meth"), wantOffset: 2},
+		{name: "CBOR Trailing Data", codec: codec.CBOR{}, input: []byte("\xa0\x01"), wantOffset: 1},
+		{name: "CBOR Indefinite Length", codec: codec.CBOR{}, input: []byte("\xbf\xff"), wantOffset: 1},
+		{name: "CBOR Reserved Info", codec: codec.CBOR{}, input: []byte("\xa1\x61n\x1c"), wantOffset: 4},
+		{name: "CBOR Not A Map", codec: codec.CBOR{}, input: []byte("\x82\x01\x02"), wantType: true},
+		{name: "CBOR Deep Nesting", codec: codec.CBOR{}, input: append([]byte("\xa1\x61n"), bytes.Repeat([]byte{0x81}, 100)...), wantOffset: 68},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := tc.codec.DecodeMap(tc.input)
+			is.True(err != nil) // expected an error
+
+			if tc.wantType {
+				var typeErr *codec.TypeError
+				is.True(errors.As(err, &typeErr)) // expected a type error
+				return
+			}
+
+			var syntaxErr *codec.SyntaxError
+			is.True(errors.As(err, &syntaxErr)) // expected a syntax error
+			is.Equal(syntaxErr.Offset, tc.wantOffset)
+		})
+	}
+}
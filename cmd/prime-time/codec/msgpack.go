@@ -0,0 +1,272 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// MessagePack implements Codec for https://msgpack.org. Extension types are
+// not supported.
+type MessagePack struct{}
+
+func (MessagePack) Name() string {
+	return "msgpack"
+}
+
+func (MessagePack) Decode(b []byte) (interface{}, error) {
+	d := &msgpackDecoder{decoder{buf: b}}
+	return d.decode(d.value)
+}
+
+func (MessagePack) DecodeMap(b []byte) ([]Field, error) {
+	d := &msgpackDecoder{decoder{buf: b}}
+	return d.decodeMap(d.mapLen, d.value)
+}
+
+type msgpackDecoder struct {
+	decoder
+}
+
+func (d *msgpackDecoder) mapLen() (uint64, bool, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case b >= 0x80 && b <= 0x8f:
+		return uint64(b & 0x0f), true, nil
+	case b == 0xde:
+		n, err := d.readUint(2)
+		return n, true, err
+	case b == 0xdf:
+		n, err := d.readUint(4)
+		return n, true, err
+	}
+
+	return 0, false, nil
+}
+
+func (d *msgpackDecoder) value() (interface{}, error) {
+	start := d.pos
+
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0x80 && b <= 0x8f:
+		return d.mapValue(uint64(b & 0x0f))
+	case b >= 0x90 && b <= 0x9f:
+		return d.arrayValue(uint64(b & 0x0f))
+	case b >= 0xa0 && b <= 0xbf:
+		return d.strValue(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.read(n)
+	case 0xca:
+		n, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (b - 0xcc))
+		return uintValue(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.readUint(size)
+		// sign extend from the encoded width
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.strValue(n)
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(n)
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n)
+	}
+
+	d.pos = start
+	return nil, d.errorf("unsupported type 0x%02x", b)
+}
+
+func (d *msgpackDecoder) strValue(n uint64) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) arrayValue(n uint64) (interface{}, error) {
+	if err := d.checkCount(n); err != nil {
+		return nil, err
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapValue(n uint64) (interface{}, error) {
+	if err := d.checkCount(n); err != nil {
+		return nil, err
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[keyString(k)] = v
+	}
+	return m, nil
+}
+
+func (m MessagePack) Encode(v interface{}) ([]byte, error) {
+	return m.encode(nil, v)
+}
+
+func (m MessagePack) encode(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int:
+		return m.encodeInt(buf, int64(v)), nil
+	case int64:
+		return m.encodeInt(buf, v), nil
+	case uint64:
+		return m.encodeUint(buf, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(v)), nil
+	case string:
+		buf = m.encodeHead(buf, uint64(len(v)), 0xa0, 0x1f, 0xd9, 0xda, 0xdb)
+		return append(buf, v...), nil
+	case []interface{}:
+		buf = m.encodeHead(buf, uint64(len(v)), 0x90, 0x0f, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			var err error
+			if buf, err = m.encode(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = m.encodeHead(buf, uint64(len(v)), 0x80, 0x0f, 0, 0xde, 0xdf)
+		for _, k := range sortedKeys(v) {
+			var err error
+			if buf, err = m.encode(buf, k); err != nil {
+				return nil, err
+			}
+			if buf, err = m.encode(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	return nil, fmt.Errorf("msgpack: cannot encode %T", v)
+}
+
+// encodeHead writes a string, array or map header. Lengths up to fixMax are
+// packed into the fix byte, otherwise the smallest of the 8, 16 and 32 bit
+// forms is used. Arrays and maps have no 8 bit form, signalled by b8 == 0.
+func (MessagePack) encodeHead(buf []byte, n uint64, fix byte, fixMax uint64, b8, b16, b32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint8 && b8 != 0:
+		return append(buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, b16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, b32), uint32(n))
+	}
+}
+
+func (m MessagePack) encodeInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return m.encodeUint(buf, uint64(n))
+	case n >= -32:
+		return append(buf, byte(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+	}
+}
+
+func (MessagePack) encodeUint(buf []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(buf, byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), n)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maximum size of an HTTP request body
+const maxBodyBytes = 1 << 20
+
+// newHTTPHandler serves the same requests as the TCP listener. Bodies and query
+// parameters are turned into request lines and answered by handleLine so that
+// both transports always agree.
+func newHTTPHandler(handleLine lineHandler) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		line, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "read failed", http.StatusBadRequest)
+			return
+		}
+
+		writeHTTPResponse(w, handleLine, line)
+	})
+
+	mux.HandleFunc("/isPrime", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		line, err := queryToLine(r)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeHTTPResponse(w, handleLine, line)
+	})
+
+	return mux
+}
+
+// queryToLine builds the JSON request equivalent to an isPrime query. A number
+// that is not a valid JSON value is passed on as a string so that request
+// validation rejects it exactly as it would over TCP.
+func queryToLine(r *http.Request) ([]byte, error) {
+	req := map[string]interface{}{"method": "isPrime"}
+
+	if r.URL.Query().Has("number") {
+		number := r.URL.Query().Get("number")
+		if json.Valid([]byte(number)) {
+			req["number"] = json.RawMessage(number)
+		} else {
+			req["number"] = number
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+func writeHTTPResponse(w http.ResponseWriter, handleLine lineHandler, line []byte) {
+	log.Println("received over HTTP:", string(line))
+
+	resBytes, valid, err := handleLine(line)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// verbose diagnostics are JSON while the default malformed response is not
+	if json.Valid(resBytes) {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	if valid {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	w.Write(resBytes)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// transportCases are sent over both TCP and HTTP. query holds the equivalent
+// GET /isPrime query string for cases that can be expressed as one.
+var transportCases = []struct {
+	name      string
+	line      string
+	query     string
+	wantValid bool
+}{
+	{name: "Not Prime", line: `{"method":"isPrime","number":1}`, query: "number=1", wantValid: true},
+	{name: "Prime", line: `{"method":"isPrime","number":7}`, query: "number=7", wantValid: true},
+	{name: "Large Prime", line: `{"method":"isPrime","number":999983}`, query: "number=999983", wantValid: true},
+	{name: "Fraction", line: `{"method":"isPrime","number":7.5}`, query: "number=7.5", wantValid: true},
+	{name: "Negative", line: `{"method":"isPrime","number":-7}`, query: "number=-7", wantValid: true},
+	{name: "Number As String", line: `{"method":"isPrime","number":"ABC"}`, query: "number=ABC", wantValid: false},
+	{name: "Number As Object", line: `{"method":"isPrime","number":{"n":7}}`, query: "number=%7B%22n%22:7%7D", wantValid: false},
+	{name: "Missing Number", line: `{"method":"isPrime"}`, query: "unrelated=7", wantValid: false},
+	{name: "Unknown Method", line: `{"method":"isComposite","number":7}`, wantValid: false},
+	{name: "Garbage", line: `garbage_request`, wantValid: false},
+}
+
+func tcpResponse(t *testing.T, conn func(net.Conn) error, line string) string {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go conn(server)
+
+	go client.Write([]byte(line + "\n"))
+
+	res, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatalf("tcp read: %s", err)
+	}
+	return res
+}
+
+func httpGet(t *testing.T, url string) (string, int) {
+	res, err := http.Get(url)
+	return readHTTPResponse(t, res, err)
+}
+
+func httpPost(t *testing.T, url string, body string) (string, int) {
+	res, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	return readHTTPResponse(t, res, err)
+}
+
+func readHTTPResponse(t *testing.T, res *http.Response, err error) (string, int) {
+	if err != nil {
+		t.Fatalf("http request: %s", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("http read: %s", err)
+	}
+	return string(body), res.StatusCode
+}
+
+func TestTransportsAgree(t *testing.T) {
+	modes := []struct {
+		name       string
+		handler    func(net.Conn) error
+		handleLine lineHandler
+	}{
+		{name: "Default", handler: handle, handleLine: handleLine},
+		{name: "Verbose", handler: handleVerbose, handleLine: handleLineVerbose},
+	}
+
+	for _, mode := range modes {
+		svr := httptest.NewServer(newHTTPHandler(mode.handleLine))
+		defer svr.Close()
+
+		for _, tc := range transportCases {
+			mode, tc := mode, tc
+			t.Run(mode.name+"/"+tc.name, func(t *testing.T) {
+				is := is.New(t)
+
+				wantStatus := http.StatusOK
+				if !tc.wantValid {
+					wantStatus = http.StatusBadRequest
+				}
+
+				tcpRes := tcpResponse(t, mode.handler, tc.line)
+
+				postRes, postStatus := httpPost(t, svr.URL+"/", tc.line)
+				is.Equal(postRes, tcpRes)        // POST response differs from TCP
+				is.Equal(postStatus, wantStatus) // unexpected POST status
+
+				if tc.query != "" {
+					getRes, getStatus := httpGet(t, svr.URL+"/isPrime?"+tc.query)
+					is.Equal(getRes, tcpRes)        // GET response differs from TCP
+					is.Equal(getStatus, wantStatus) // unexpected GET status
+				}
+			})
+		}
+	}
+}
+
+func TestHTTPRoutes(t *testing.T) {
+	is := is.New(t)
+
+	svr := httptest.NewServer(newHTTPHandler(handleLine))
+	defer svr.Close()
+
+	_, status := httpGet(t, svr.URL+"/")
+	is.Equal(status, http.StatusMethodNotAllowed) // GET / is not allowed
+
+	_, status = httpPost(t, svr.URL+"/isPrime?number=7", "")
+	is.Equal(status, http.StatusMethodNotAllowed) // POST /isPrime is not allowed
+
+	_, status = httpGet(t, svr.URL+"/unknown")
+	is.Equal(status, http.StatusNotFound) // unknown path
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"math"
 	"math/big"
 	"net"
+	"net/http"
 
 	"github.com/russellslater/protohackers"
 )
@@ -21,11 +24,49 @@ type primeResponse struct {
 	Prime  bool   `json:"prime"`
 }
 
+// lineHandler produces the response to a single request line and whether the
+// request was valid
+type lineHandler func(line []byte) ([]byte, bool, error)
+
 func main() {
-	log.Fatal(protohackers.ListenAndAccept(5000, handle))
+	var verbose bool
+	var httpPort int
+	var binaryPort int
+	flag.BoolVar(&verbose, "verbose", false, "Describe why a request was malformed instead of replying 'invalid request'")
+	flag.IntVar(&httpPort, "http-port", 0, "Port for the optional HTTP listener (disabled when 0)")
+	flag.IntVar(&binaryPort, "binary-port", 0, "Port for the optional MessagePack/CBOR listener (disabled when 0)")
+	flag.Parse()
+
+	handler, lineFn, binaryFn := handle, handleLine, handleBinary
+	if verbose {
+		handler, lineFn, binaryFn = handleVerbose, handleLineVerbose, handleBinaryVerbose
+	}
+
+	if binaryPort != 0 {
+		go func() {
+			log.Fatal(protohackers.ListenAndAccept(binaryPort, binaryFn))
+		}()
+	}
+
+	if httpPort != 0 {
+		go func() {
+			log.Println("listening for HTTP on port", httpPort)
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpPort), newHTTPHandler(lineFn)))
+		}()
+	}
+
+	log.Fatal(protohackers.ListenAndAccept(5000, handler))
 }
 
 func handle(conn net.Conn) error {
+	return serveLines(conn, handleLine)
+}
+
+func handleVerbose(conn net.Conn) error {
+	return serveLines(conn, handleLineVerbose)
+}
+
+func serveLines(conn net.Conn, handleLine lineHandler) error {
 	defer conn.Close()
 
 	scanner := bufio.NewScanner(conn)
@@ -53,11 +94,30 @@ func handle(conn net.Conn) error {
 }
 
 func handleLine(line []byte) ([]byte, bool, error) {
-	var req primeRequest
-	if err := json.Unmarshal(line, &req); err != nil || !isValidPrimeRequest(req) {
+	req, reqErr := parseRequest(line)
+	if reqErr != nil {
 		return []byte("invalid request\n"), false, nil
 	}
 
+	return respond(req)
+}
+
+// handleLineVerbose replies to a malformed request with a JSON object naming
+// the failure category and the byte offset at which it was detected.
+func handleLineVerbose(line []byte) ([]byte, bool, error) {
+	req, reqErr := parseRequest(line)
+	if reqErr != nil {
+		resBytes, err := json.Marshal(reqErr)
+		if err != nil {
+			return nil, false, err
+		}
+		return append(resBytes, []byte("\n")...), false, nil
+	}
+
+	return respond(req)
+}
+
+func respond(req primeRequest) ([]byte, bool, error) {
 	resBytes, err := json.Marshal(primeResponse{Method: "isPrime", Prime: isPrime(*req.Number)})
 	if err != nil {
 		return nil, true, err
@@ -66,10 +126,6 @@ func handleLine(line []byte) ([]byte, bool, error) {
 	return append(resBytes, []byte("\n")...), true, nil
 }
 
-func isValidPrimeRequest(req primeRequest) bool {
-	return req.Method == "isPrime" && req.Number != nil
-}
-
 func isPrime(n float64) bool {
 	// prime numbers are positive integers
 	if n < 0 || n != math.Trunc(n) {
@@ -91,3 +91,124 @@ func TestValidLines(t *testing.T) {
 		}
 	}
 }
+
+func TestVerboseLines(t *testing.T) {
+	tt := []struct {
+		name      string
+		line      []byte
+		wantBytes []byte
+		wantValid bool
+	}{
+		{
+			name:      "Valid Request",
+			line:      []byte("{\"method\":\"isPrime\",\"number\":7}"),
+			wantBytes: []byte("{\"method\":\"isPrime\",\"prime\":true}\n"),
+			wantValid: true,
+		},
+		{
+			name:      "Bad JSON",
+			line:      []byte("garbage_request"),
+			wantBytes: []byte("{\"error\":\"bad json\",\"offset\":1}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Unterminated Object",
+			line:      []byte("{\"method\":\"isPrime\",\"number\":7"),
+			wantBytes: []byte("{\"error\":\"bad json\",\"offset\":30}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Trailing Data",
+			line:      []byte("{\"method\":\"isPrime\",\"number\":7}{}"),
+			wantBytes: []byte("{\"error\":\"bad json\",\"offset\":32}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Missing Method",
+			line:      []byte("{}"),
+			wantBytes: []byte("{\"error\":\"missing field\",\"field\":\"method\",\"offset\":2}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Missing Number",
+			line:      []byte("{\"method\":\"isPrime\"}"),
+			wantBytes: []byte("{\"error\":\"missing field\",\"field\":\"number\",\"offset\":20}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Number Is A String",
+			line:      []byte("{\"method\":\"isPrime\",\"number\":\"not-a-number\"}"),
+			wantBytes: []byte("{\"error\":\"wrong type\",\"field\":\"number\",\"offset\":29}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Number Is Null",
+			line:      []byte("{\"method\":\"isPrime\", \"number\": null}"),
+			wantBytes: []byte("{\"error\":\"wrong type\",\"field\":\"number\",\"offset\":31}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Method Is A Number",
+			line:      []byte("{\"method\":5,\"number\":7}"),
+			wantBytes: []byte("{\"error\":\"wrong type\",\"field\":\"method\",\"offset\":10}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Not An Object",
+			line:      []byte("[7]"),
+			wantBytes: []byte("{\"error\":\"wrong type\",\"offset\":0}\n"),
+			wantValid: false,
+		},
+		{
+			name:      "Unknown Method",
+			line:      []byte("{\"method\":\"isComposite\",\"number\":7}"),
+			wantBytes: []byte("{\"error\":\"unknown method\",\"field\":\"method\",\"offset\":10}\n"),
+			wantValid: false,
+		},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			gotBytes, gotValid, _ := handleLineVerbose(tc.line)
+			if string(gotBytes) != string(tc.wantBytes) {
+				t.Errorf("got bytes %q, want %q", gotBytes, tc.wantBytes)
+			}
+			if gotValid != tc.wantValid {
+				t.Errorf("got valid %t, want %t", gotValid, tc.wantValid)
+			}
+
+			// the default mode must still send the checker's expected response
+			gotBytes, _, _ = handleLine(tc.line)
+			if !tc.wantValid && string(gotBytes) != "invalid request\n" {
+				t.Errorf("got default bytes %q, want %q", gotBytes, "invalid request\n")
+			}
+		})
+	}
+}
+
+func TestPrimeTimeVerboseHandler(t *testing.T) {
+	is := is.New(t)
+
+	client, server := net.Pipe()
+
+	go func() {
+		handleVerbose(server)
+		server.Close()
+	}()
+
+	clientScanner := bufio.NewScanner(client)
+
+	client.Write([]byte("{\"method\":\"isPrime\",\"number\":7}\n"))
+	clientScanner.Scan()
+	is.Equal(clientScanner.Text(), "{\"method\":\"isPrime\",\"prime\":true}")
+
+	client.Write([]byte("{\"method\":\"isPrime\",\"number\":ABC}\n"))
+	clientScanner.Scan()
+	is.Equal(clientScanner.Text(), "{\"error\":\"bad json\",\"offset\":30}")
+
+	client.Write([]byte("{\"method\":\"isPrime\",\"number\":7}\n"))
+	clientScanner.Scan()
+	is.Equal(len(clientScanner.Text()), 0) // server hung up after invalid request
+
+	client.Close()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// failure categories reported in verbose mode
+const (
+	badJSON       = "bad json"
+	badEncoding   = "bad encoding" // MessagePack or CBOR frames
+	missingField  = "missing field"
+	wrongType     = "wrong type"
+	unknownMethod = "unknown method"
+)
+
+type requestError struct {
+	Category string `json:"error"`
+	Field    string `json:"field,omitempty"`
+	Offset   int64  `json:"offset"`
+}
+
+func (e *requestError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %q at offset %d", e.Category, e.Field, e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d", e.Category, e.Offset)
+}
+
+// requestField is a top-level value of a request along with the byte offset
+// at which the value starts.
+type requestField struct {
+	value  interface{}
+	offset int64
+}
+
+// requestFields holds the top-level fields of a request keyed by lowercase
+// name, mirroring the case-insensitive matching of encoding/json.
+type requestFields map[string]requestField
+
+func parseRequest(line []byte) (primeRequest, *requestError) {
+	fields, reqErr := decodeJSONRequest(line)
+	if reqErr != nil {
+		return primeRequest{}, reqErr
+	}
+	return validateRequest(fields, int64(len(line)))
+}
+
+func decodeJSONRequest(line []byte) (requestFields, *requestError) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(err, line)
+	}
+	if tok != json.Delim('{') {
+		return nil, &requestError{Category: wrongType, Offset: 0}
+	}
+
+	fields := make(requestFields)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, jsonError(err, line)
+		}
+		key := tok.(string) // object keys are always strings
+
+		offset := valueOffset(line, dec.InputOffset())
+
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, jsonError(err, line)
+		}
+
+		fields[strings.ToLower(key)] = requestField{value: value, offset: offset}
+	}
+
+	// consume the closing brace
+	if _, err := dec.Token(); err != nil {
+		return nil, jsonError(err, line)
+	}
+
+	// nothing but whitespace may follow the object
+	if _, err := dec.Token(); err != io.EOF {
+		if err != nil {
+			return nil, jsonError(err, line)
+		}
+		return nil, &requestError{Category: badJSON, Offset: dec.InputOffset()}
+	}
+
+	return fields, nil
+}
+
+// valueOffset skips the whitespace and colon following an object key.
+func valueOffset(line []byte, offset int64) int64 {
+	for offset < int64(len(line)) && strings.IndexByte(" \t\r\n:", line[offset]) != -1 {
+		offset++
+	}
+	return offset
+}
+
+func jsonError(err error, line []byte) *requestError {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return &requestError{Category: badJSON, Offset: syntaxErr.Offset}
+	}
+	// the request ended before the object was complete
+	return &requestError{Category: badJSON, Offset: int64(len(line))}
+}
+
+func validateRequest(fields requestFields, end int64) (primeRequest, *requestError) {
+	method, ok := fields["method"]
+	if !ok {
+		return primeRequest{}, &requestError{Category: missingField, Field: "method", Offset: end}
+	}
+
+	name, ok := method.value.(string)
+	if !ok {
+		return primeRequest{}, &requestError{Category: wrongType, Field: "method", Offset: method.offset}
+	}
+	if name != "isPrime" {
+		return primeRequest{}, &requestError{Category: unknownMethod, Field: "method", Offset: method.offset}
+	}
+
+	number, ok := fields["number"]
+	if !ok {
+		return primeRequest{}, &requestError{Category: missingField, Field: "number", Offset: end}
+	}
+
+	n, ok := toFloat(number.value)
+	if !ok {
+		return primeRequest{}, &requestError{Category: wrongType, Field: "number", Offset: number.offset}
+	}
+
+	return primeRequest{Method: name, Number: &n}, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -2,4 +2,8 @@ module github.com/russellslater/protohackers
 
 go 1.19
 
-require github.com/matryer/is v1.4.0
+require (
+	github.com/matryer/is v1.4.0
+	golang.org/x/crypto v0.24.0
+	golang.org/x/text v0.16.0
+)
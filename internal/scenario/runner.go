@@ -0,0 +1,136 @@
+package scenario
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// peer buffers what arrives on a connection, so that a server is never held
+// up writing while the scenario is sending.
+type peer struct {
+	conn   net.Conn
+	chunks chan []byte // closed when the connection is
+	buf    []byte
+	closed bool
+}
+
+func newPeer(conn net.Conn) *peer {
+	p := &peer{conn: conn, chunks: make(chan []byte)}
+
+	go func() {
+		defer close(p.chunks)
+		for {
+			b := make([]byte, 4096)
+			n, err := conn.Read(b)
+			if n > 0 {
+				p.chunks <- b[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// fill waits until at least n bytes are buffered, the connection is closed or
+// the deadline passes.
+func (p *peer) fill(n int, deadline <-chan time.Time) {
+	for len(p.buf) < n && !p.closed {
+		select {
+		case b, ok := <-p.chunks:
+			if !ok {
+				p.closed = true
+			}
+			p.buf = append(p.buf, b...)
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// Run executes the steps in order, calling connect the first time each
+// connection is used. The connections are closed when Run returns.
+func (s *Scenario) Run(connect func(name string) (net.Conn, error)) error {
+	peers := make(map[string]*peer)
+	defer func() {
+		for _, p := range peers {
+			p.conn.Close()
+			// unblock the reader if it is waiting to deliver a chunk
+			for range p.chunks {
+			}
+		}
+	}()
+
+	for _, step := range s.Steps {
+		p, ok := peers[step.Conn]
+		if !ok {
+			conn, err := connect(step.Conn)
+			if err != nil {
+				return s.errorf(step, "connect: %w", err)
+			}
+			p = newPeer(conn)
+			peers[step.Conn] = p
+		}
+
+		if err := s.run(step, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Scenario) run(step Step, p *peer) error {
+	deadline := time.After(s.Timeout)
+
+	if !step.Expect {
+		var b []byte
+		for _, f := range step.Fields {
+			b = append(b, f.Bytes...)
+		}
+
+		p.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		if _, err := p.conn.Write(b); err != nil {
+			return s.errorf(step, "send: %w", err)
+		}
+		return nil
+	}
+
+	if step.EOF {
+		p.fill(len(p.buf)+1, deadline)
+		if len(p.buf) > 0 {
+			return s.errorf(step, "expect eof: got % x", p.buf)
+		}
+		if !p.closed {
+			return s.errorf(step, "expect eof: connection still open after %s", s.Timeout)
+		}
+		return nil
+	}
+
+	for _, f := range step.Fields {
+		p.fill(len(f.Bytes), deadline)
+
+		if len(p.buf) < len(f.Bytes) {
+			if p.closed {
+				return s.errorf(step, "expect %s: connection closed after % x", f.Text, p.buf)
+			}
+			return s.errorf(step, "expect %s: timed out after %s with % x", f.Text, s.Timeout, p.buf)
+		}
+
+		got := p.buf[:len(f.Bytes)]
+		if !bytes.Equal(got, f.Bytes) {
+			return s.errorf(step, "expect %s: got % x, want % x", f.Text, got, f.Bytes)
+		}
+		p.buf = p.buf[len(f.Bytes):]
+	}
+
+	return nil
+}
+
+func (s *Scenario) errorf(step Step, format string, a ...interface{}) error {
+	return fmt.Errorf("%s:%d: @%s %w", s.Name, step.Line, step.Conn, fmt.Errorf(format, a...))
+}
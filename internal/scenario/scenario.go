@@ -0,0 +1,241 @@
+// Package scenario runs scripted conversations against binary protocol
+// servers.
+//
+// A scenario is a text file of steps, one per line, each sending bytes on a
+// connection or expecting bytes to arrive on it:
+//
+//	# a camera reports a plate
+//	@camera send u8:0x80 u16:123 u16:8 u16:60
+//	@camera send u8:0x20 str:UN1X u32:0
+//	@dispatcher expect u8:0x21 str:"UN1X" u16:123
+//	@dispatcher expect eof
+//
+// The connection named after @ may be left out, in which case the step uses
+// the connection of the step before it, or "client" for the first step.
+// Fields are written type:value, where the type is one of
+//
+//	u8, u16, u32      unsigned big endian integers
+//	i32, i64          signed big endian integers
+//	char              a single byte given as a character, e.g. char:I
+//	str               a string preceded by a u8 length
+//	raw               the bytes of a string without a length
+//
+// Integers may be written in decimal or, with a 0x prefix, in hex. Strings
+// containing spaces are quoted using Go syntax. Lines starting with # are
+// comments.
+package scenario
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConn names the connection used until a step names another.
+const DefaultConn = "client"
+
+// DefaultTimeout bounds how long a step waits to send or for expected bytes.
+const DefaultTimeout = 2 * time.Second
+
+type Scenario struct {
+	Name    string
+	Steps   []Step
+	Timeout time.Duration
+}
+
+type Step struct {
+	Line   int
+	Conn   string
+	Expect bool    // send the fields otherwise
+	EOF    bool    // expect the connection to be closed
+	Fields []Field // empty for an EOF step
+}
+
+type Field struct {
+	Text  string // as written in the scenario
+	Bytes []byte
+}
+
+// ParseFile reads a scenario from a file, named after the file.
+func ParseFile(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(path, f)
+}
+
+// Parse reads a scenario, using name to identify it in errors.
+func Parse(name string, r io.Reader) (*Scenario, error) {
+	s := &Scenario{Name: name, Timeout: DefaultTimeout}
+
+	conn := DefaultConn
+
+	scn := bufio.NewScanner(r)
+	for line := 1; scn.Scan(); line++ {
+		text := strings.TrimSpace(scn.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		step, err := parseStep(text, conn)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		step.Line = line
+		conn = step.Conn
+
+		s.Steps = append(s.Steps, step)
+	}
+
+	if err := scn.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return s, nil
+}
+
+func parseStep(text string, conn string) (Step, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return Step{}, err
+	}
+
+	if strings.HasPrefix(tokens[0], "@") {
+		conn, tokens = tokens[0][1:], tokens[1:]
+		if conn == "" {
+			return Step{}, errors.New("missing connection name after @")
+		}
+	}
+
+	if len(tokens) == 0 {
+		return Step{}, errors.New("missing send or expect")
+	}
+
+	step := Step{Conn: conn}
+
+	switch tokens[0] {
+	case "send":
+	case "expect":
+		step.Expect = true
+	default:
+		return Step{}, fmt.Errorf("unknown action %q, want send or expect", tokens[0])
+	}
+
+	fields := tokens[1:]
+	if step.Expect && len(fields) == 1 && fields[0] == "eof" {
+		step.EOF = true
+		return step, nil
+	}
+
+	if len(fields) == 0 {
+		return Step{}, fmt.Errorf("%s has no fields", tokens[0])
+	}
+
+	for _, text := range fields {
+		b, err := encodeField(text)
+		if err != nil {
+			return Step{}, err
+		}
+		step.Fields = append(step.Fields, Field{Text: text, Bytes: b})
+	}
+
+	return step, nil
+}
+
+// tokenize splits a line on spaces, except for those within quotes.
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+
+	quoted, escaped := false, false
+	for _, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+			continue
+		}
+		token.WriteRune(r)
+	}
+
+	if quoted {
+		return nil, errors.New("unterminated string")
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+func encodeField(text string) ([]byte, error) {
+	typ, value, ok := strings.Cut(text, ":")
+	if !ok {
+		return nil, fmt.Errorf("field %q is not written type:value", text)
+	}
+
+	switch typ {
+	case "u8", "u16", "u32":
+		bits, _ := strconv.Atoi(typ[1:])
+		n, err := strconv.ParseUint(value, 0, bits)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", text, err)
+		}
+		return appendInt(nil, n, bits/8), nil
+	case "i32", "i64":
+		bits, _ := strconv.Atoi(typ[1:])
+		n, err := strconv.ParseInt(value, 0, bits)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", text, err)
+		}
+		return appendInt(nil, uint64(n), bits/8), nil
+	case "char":
+		if len(value) != 1 {
+			return nil, fmt.Errorf("field %q is not a single byte", text)
+		}
+		return []byte(value), nil
+	case "str", "raw":
+		s, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", text, err)
+		}
+		if typ == "raw" {
+			return []byte(s), nil
+		}
+		if len(s) > 255 {
+			return nil, fmt.Errorf("field %q is longer than 255 bytes", text)
+		}
+		return append([]byte{byte(len(s))}, s...), nil
+	}
+
+	return nil, fmt.Errorf("field %q has unknown type %q", text, typ)
+}
+
+func unquote(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) {
+		return strconv.Unquote(value)
+	}
+	return value, nil
+}
+
+func appendInt(b []byte, n uint64, size int) []byte {
+	buf := binary.BigEndian.AppendUint64(nil, n)
+	return append(b, buf[8-size:]...)
+}
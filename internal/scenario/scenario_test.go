@@ -0,0 +1,166 @@
+package scenario_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/internal/scenario"
+)
+
+func TestParseFields(t *testing.T) {
+	tt := []struct {
+		name  string
+		field string
+		want  []byte
+	}{
+		{name: "u8", field: "u8:0x80", want: []byte{0x80}},
+		{name: "u16", field: "u16:123", want: []byte{0x00, 0x7b}},
+		{name: "u32", field: "u32:0xdeadbeef", want: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{name: "i32", field: "i32:-1", want: []byte{0xff, 0xff, 0xff, 0xff}},
+		{name: "i64", field: "i64:4294967294", want: []byte{0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xfe}},
+		{name: "char", field: "char:I", want: []byte{'I'}},
+		{name: "str", field: "str:UN1X", want: []byte{0x04, 'U', 'N', '1', 'X'}},
+		{name: "Quoted str", field: `str:"a b"`, want: []byte{0x03, 'a', ' ', 'b'}},
+		{name: "raw", field: `raw:"AB\x00"`, want: []byte{'A', 'B', 0x00}},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s, err := scenario.Parse("test", strings.NewReader("send "+tc.field))
+			is.NoErr(err)
+			is.Equal(len(s.Steps), 1)
+			is.Equal(s.Steps[0].Fields[0].Bytes, tc.want)
+		})
+	}
+}
+
+func TestParseConnections(t *testing.T) {
+	is := is.New(t)
+
+	s, err := scenario.Parse("test", strings.NewReader(`
+# comment
+send u8:1
+@camera send u8:2
+expect u8:3
+
+@dispatcher expect eof
+`))
+	is.NoErr(err)
+	is.Equal(len(s.Steps), 4)
+
+	is.Equal(s.Steps[0].Conn, scenario.DefaultConn)
+	is.Equal(s.Steps[1].Conn, "camera")
+	is.Equal(s.Steps[2].Conn, "camera") // carried over from the step before
+	is.True(s.Steps[2].Expect)
+	is.Equal(s.Steps[3].Line, 7)
+	is.True(s.Steps[3].EOF)
+}
+
+func TestParseErrors(t *testing.T) {
+	tt := []struct {
+		name string
+		line string
+	}{
+		{name: "Unknown Action", line: "receive u8:1"},
+		{name: "Missing Action", line: "@camera"},
+		{name: "Missing Connection Name", line: "@ send u8:1"},
+		{name: "No Fields", line: "send"},
+		{name: "Unknown Type", line: "send u24:1"},
+		{name: "Missing Type", line: "send 1"},
+		{name: "Out Of Range", line: "send u8:256"},
+		{name: "Long Char", line: "send char:IQ"},
+		{name: "Unterminated String", line: `send str:"abc`},
+		{name: "Long String", line: "send str:" + strings.Repeat("a", 256)},
+		{name: "Send EOF", line: "send eof"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := scenario.Parse("test", strings.NewReader(tc.line))
+			if err == nil {
+				t.Errorf("parsed %q, want error", tc.line)
+			}
+		})
+	}
+}
+
+// echoOnce answers the first byte it reads with the byte after it, then hangs
+// up.
+func echoOnce(conn net.Conn) {
+	defer conn.Close()
+
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return
+	}
+	conn.Write([]byte{b[0] + 1})
+}
+
+func pipeTo(serve func(net.Conn)) func(string) (net.Conn, error) {
+	return func(string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serve(server)
+		return client, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	tt := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			name:   "Success",
+			script: "send u8:1\nexpect u8:2\nexpect eof\n@other send u8:5\n@other expect u8:6",
+		},
+		{
+			name:    "Mismatch",
+			script:  "send u8:1\nexpect u8:3",
+			wantErr: "test:2: @client expect u8:3: got 02, want 03",
+		},
+		{
+			name:    "Closed Early",
+			script:  "send u8:1\nexpect u16:2",
+			wantErr: "test:2: @client expect u16:2: connection closed after 02",
+		},
+		{
+			name:    "Bytes Before EOF",
+			script:  "send u8:1\nexpect eof",
+			wantErr: "test:2: @client expect eof: got 02",
+		},
+		{
+			name:    "Timeout",
+			script:  "expect u8:1",
+			wantErr: "test:1: @client expect u8:1: timed out after 50ms with ",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s, err := scenario.Parse("test", strings.NewReader(tc.script))
+			is.NoErr(err)
+			s.Timeout = 50 * time.Millisecond
+
+			err = s.Run(pipeTo(echoOnce))
+			if tc.wantErr == "" {
+				is.NoErr(err)
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
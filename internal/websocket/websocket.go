@@ -0,0 +1,380 @@
+// Package websocket implements enough of RFC 6455 to exchange text and binary
+// messages with browsers: the opening handshake on either side, framing with
+// fragmentation and masking, and the ping, pong and close control frames.
+// Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	continuationFrame = 0
+	closeFrame        = 8
+	pingFrame         = 9
+	pongFrame         = 10
+)
+
+// DefaultMaxMessageBytes is the default limit on the size of a message read,
+// after reassembling its fragments.
+const DefaultMaxMessageBytes = 64 << 10
+
+// closeTimeout bounds writing the close frame when closing.
+const closeTimeout = time.Second
+
+// the key from RFC 6455 that proves the server understood the handshake
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrProtocol        = errors.New("websocket: protocol error")
+)
+
+// Conn is a WebSocket connection. One goroutine may read while another
+// writes, and Close may be called from any goroutine.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool // clients mask the frames they send
+
+	// MaxMessageBytes limits the size of a message read.
+	MaxMessageBytes int
+
+	writeMu sync.Mutex
+	closed  bool // a close frame has been sent and nothing more may be written
+}
+
+func newConn(conn net.Conn, r *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, r: r, client: client, MaxMessageBytes: DefaultMaxMessageBytes}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the opening handshake of a request, taking over its
+// connection. A request that is not a valid handshake is answered with an
+// error status.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("%w: method %s", ErrProtocol, r.Method)
+	}
+
+	key := r.Header.Get("Sec-Websocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("%w: not a handshake", ErrProtocol)
+	}
+
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("%w: version %q", ErrProtocol, r.Header.Get("Sec-Websocket-Version"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response cannot be hijacked")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+
+	// the server may have set deadlines for reading the request
+	conn.SetDeadline(time.Time{})
+
+	res := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(res)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Client performs the opening handshake over conn for the given URL, whose
+// host and path are sent in the request.
+func Client(conn net.Conn, host string, path string) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: handshake status %s", ErrProtocol, res.Status)
+	}
+	if res.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w: handshake accept key", ErrProtocol)
+	}
+
+	return newConn(conn, r, true), nil
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its
+// fragments. Pings are answered as they arrive. A close frame is echoed and
+// reported as io.EOF.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var opcode int
+	var msg []byte
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case pingFrame:
+			if err := c.writeFrame(pongFrame, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongFrame:
+			continue
+		case closeFrame:
+			// echo the status code only, as the reason is optional
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.writeFrame(closeFrame, payload)
+			return 0, nil, io.EOF
+		case TextMessage, BinaryMessage:
+			if opcode != 0 {
+				return 0, nil, fmt.Errorf("%w: interleaved message", ErrProtocol)
+			}
+			opcode = op
+		case continuationFrame:
+			if opcode == 0 {
+				return 0, nil, fmt.Errorf("%w: unexpected continuation", ErrProtocol)
+			}
+		default:
+			return 0, nil, fmt.Errorf("%w: opcode %d", ErrProtocol, op)
+		}
+
+		if len(msg)+len(payload) > c.MaxMessageBytes {
+			return 0, nil, ErrMessageTooLarge
+		}
+		msg = append(msg, payload...)
+
+		if fin {
+			return opcode, msg, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	op := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+
+	// only clients mask their frames
+	if masked == c.client {
+		return false, 0, nil, fmt.Errorf("%w: masking", ErrProtocol)
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if op >= closeFrame && (!fin || length > 125) {
+		return false, 0, nil, fmt.Errorf("%w: control frame", ErrProtocol)
+	}
+	if length > uint64(c.MaxMessageBytes) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text or binary message as a single frame.
+func (c *Conn) WriteMessage(opcode int, msg []byte) error {
+	return c.writeFrame(opcode, msg)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	if op == closeFrame {
+		c.closed = true
+	}
+
+	frame, err := c.frame(op, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+// frame encodes a single frame holding the whole payload.
+func (c *Conn) frame(op int, payload []byte) ([]byte, error) {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(op))
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if !c.client {
+		return append(frame, payload...), nil
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return nil, err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame, nil
+}
+
+// Close sends a normal closure frame and closes the connection. The frame is
+// skipped while another write is in progress, so that a peer that has stopped
+// reading cannot hold up closing.
+func (c *Conn) Close() error {
+	if c.writeMu.TryLock() {
+		if !c.closed {
+			c.closed = true
+			if frame, err := c.frame(closeFrame, []byte{0x03, 0xe8}); err == nil { // 1000, normal closure
+				c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+				c.conn.Write(frame)
+			}
+		}
+		c.writeMu.Unlock()
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
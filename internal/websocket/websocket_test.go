@@ -0,0 +1,214 @@
+package websocket_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/russellslater/protohackers/internal/websocket"
+)
+
+// startEchoServer echoes each message back with its opcode, reporting the
+// error that ended the connection.
+func startEchoServer(t *testing.T, maxMessageBytes int) (*httptest.Server, chan error) {
+	done := make(chan error, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if maxMessageBytes > 0 {
+			conn.MaxMessageBytes = maxMessageBytes
+		}
+
+		for {
+			op, msg, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := conn.WriteMessage(op, msg); err != nil {
+				done <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, done
+}
+
+func dial(t *testing.T, srv *httptest.Server) net.Conn {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestEcho(t *testing.T) {
+	is := is.New(t)
+
+	srv, done := startEchoServer(t, 1<<20)
+
+	conn, err := websocket.Client(dial(t, srv), "example.com", "/")
+	is.NoErr(err)
+	conn.MaxMessageBytes = 1 << 20
+
+	tt := []struct {
+		name   string
+		opcode int
+		msg    string
+	}{
+		{name: "Text", opcode: websocket.TextMessage, msg: "hello"},
+		{name: "Binary", opcode: websocket.BinaryMessage, msg: "\x00\x01\x02"},
+		{name: "Empty", opcode: websocket.TextMessage, msg: ""},
+		{name: "16 Bit Length", opcode: websocket.TextMessage, msg: strings.Repeat("a", 300)},
+		{name: "64 Bit Length", opcode: websocket.TextMessage, msg: strings.Repeat("b", 70000)},
+	}
+
+	for _, tc := range tt {
+		is.NoErr(conn.WriteMessage(tc.opcode, []byte(tc.msg)))
+
+		op, msg, err := conn.ReadMessage()
+		is.NoErr(err)
+		is.Equal(op, tc.opcode)       // opcode
+		is.Equal(string(msg), tc.msg) // message
+	}
+
+	is.NoErr(conn.Close())
+	is.Equal(<-done, io.EOF) // close frame ends the server's reads
+}
+
+// frame builds a masked client frame by hand, as Conn only sends whole
+// messages.
+func frame(fin bool, op byte, payload string) []byte {
+	b := op
+	if fin {
+		b |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	f := append([]byte{b, 0x80 | byte(len(payload))}, mask...)
+	for i := 0; i < len(payload); i++ {
+		f = append(f, payload[i]^mask[i%4])
+	}
+	return f
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	is := is.New(t)
+
+	srv, done := startEchoServer(t, 0)
+
+	raw := dial(t, srv)
+	conn, err := websocket.Client(raw, "example.com", "/")
+	is.NoErr(err)
+
+	// a ping may arrive between the fragments of a message
+	var frames []byte
+	frames = append(frames, frame(false, websocket.TextMessage, "Hel")...)
+	frames = append(frames, frame(true, 9, "are you there?")...)
+	frames = append(frames, frame(false, 0, "lo, ")...)
+	frames = append(frames, frame(true, 0, "world")...)
+	_, err = raw.Write(frames)
+	is.NoErr(err)
+
+	// the pong is handled as it arrives
+	op, msg, err := conn.ReadMessage()
+	is.NoErr(err)
+	is.Equal(op, websocket.TextMessage)
+	is.Equal(string(msg), "Hello, world")
+
+	_, err = raw.Write(frame(true, 0, "orphan"))
+	is.NoErr(err)
+	is.True(errors.Is(<-done, websocket.ErrProtocol)) // continuation without a message
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	is := is.New(t)
+
+	srv, done := startEchoServer(t, 10)
+
+	raw := dial(t, srv)
+	_, err := websocket.Client(raw, "example.com", "/")
+	is.NoErr(err)
+
+	_, err = raw.Write(append(frame(false, websocket.TextMessage, "123456"), frame(true, 0, "789012")...))
+	is.NoErr(err)
+	is.Equal(<-done, websocket.ErrMessageTooLarge)
+}
+
+func TestUnmaskedClientFrame(t *testing.T) {
+	is := is.New(t)
+
+	srv, done := startEchoServer(t, 0)
+
+	raw := dial(t, srv)
+	_, err := websocket.Client(raw, "example.com", "/")
+	is.NoErr(err)
+
+	_, err = raw.Write([]byte{0x81, 0x02, 'h', 'i'})
+	is.NoErr(err)
+	is.True(errors.Is(<-done, websocket.ErrProtocol)) // clients must mask
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	srv, _ := startEchoServer(t, 0)
+
+	tt := []struct {
+		name       string
+		request    string
+		wantStatus int
+	}{
+		{
+			name:       "Plain HTTP",
+			request:    "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Old Version",
+			request:    "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n",
+			wantStatus: http.StatusUpgradeRequired,
+		},
+		{
+			name:       "Wrong Method",
+			request:    "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			// the example from RFC 6455
+			name:       "Valid",
+			request:    "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			conn := dial(t, srv)
+			_, err := conn.Write([]byte(tc.request))
+			is.NoErr(err)
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			is.NoErr(err)
+			is.Equal(res.StatusCode, tc.wantStatus)
+
+			if tc.wantStatus == http.StatusSwitchingProtocols {
+				is.Equal(res.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+			}
+		})
+	}
+}